@@ -5,7 +5,6 @@ import (
 	"log"
 	"os"
 	"path"
-	"strings"
 
 	"github.com/fogleman/nes/ui"
 )
@@ -39,7 +38,9 @@ func getPaths() []string {
 		var result []string
 		for _, info := range infos {
 			name := info.Name()
-			if !strings.HasSuffix(name, ".nes") {
+			switch path.Ext(name) {
+			case ".nes", ".unf", ".unif", ".fds", ".nsf", ".nsfe":
+			default:
 				continue
 			}
 			result = append(result, path.Join(arg, name))
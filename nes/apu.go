@@ -2,8 +2,6 @@ package nes
 
 import "encoding/gob"
 
-const frameCounterRate = CPUFrequency / 240.0
-
 var lengthTable = []byte{
 	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
 	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
@@ -29,6 +27,14 @@ var dmcTable = []byte{
 	214, 190, 170, 160, 143, 127, 113, 107, 95, 80, 71, 64, 53, 42, 36, 27,
 }
 
+var palNoiseTable = []uint16{
+	4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778,
+}
+
+var palDMCTable = []byte{
+	199, 177, 158, 149, 138, 118, 105, 99, 88, 74, 66, 59, 49, 39, 33, 25,
+}
+
 var pulseTable [31]float32
 var tndTable [203]float32
 
@@ -57,19 +63,57 @@ type APU struct {
 	frameValue  byte
 	frameIRQ    bool
 	filterChain FilterChain
+	expansion   ExpansionAudio
+	volume      float32
 }
 
 func NewAPU(console *Console) *APU {
 	apu := APU{}
 	apu.console = console
-	apu.noise.shiftRegister = 1
-	apu.pulse1.channel = 1
-	apu.pulse2.channel = 2
-	apu.framePeriod = 4
 	apu.dmc.cpu = console.CPU
+	apu.expansion, _ = console.Mapper.(ExpansionAudio)
+	apu.volume = 1
+	apu.setTiming(&regionTimings[RegionNTSC])
+	apu.PowerCycle()
 	return &apu
 }
 
+// setTiming switches the channel period tables to those of a region
+func (apu *APU) setTiming(timing *regionTiming) {
+	apu.noise.periodTable = timing.noiseTable
+	apu.dmc.periodTable = timing.dmcTable
+}
+
+// Reset silences every channel as the reset button does. The frame counter
+// restarts in the mode last written to $4017.
+func (apu *APU) Reset() {
+	apu.writeControl(0)
+	apu.dmc.value &= 1
+	apu.cycle = 0
+	apu.frameValue = 0
+	var frameCounter byte
+	if apu.framePeriod == 5 {
+		frameCounter |= 0x80
+	}
+	if !apu.frameIRQ {
+		frameCounter |= 0x40
+	}
+	apu.writeFrameCounter(frameCounter)
+}
+
+// PowerCycle puts the APU into its power-on state, which behaves as if $00
+// had been written to $4017
+func (apu *APU) PowerCycle() {
+	apu.pulse1 = Pulse{channel: 1}
+	apu.pulse2 = Pulse{channel: 2}
+	apu.triangle = Triangle{}
+	apu.noise = Noise{shiftRegister: 1, periodTable: apu.noise.periodTable}
+	apu.dmc = DMC{cpu: apu.dmc.cpu, periodTable: apu.dmc.periodTable}
+	apu.framePeriod = 4
+	apu.frameIRQ = true
+	apu.Reset()
+}
+
 func (apu *APU) Save(encoder *gob.Encoder) error {
 	encoder.Encode(apu.cycle)
 	encoder.Encode(apu.framePeriod)
@@ -101,6 +145,10 @@ func (apu *APU) Step() {
 	apu.cycle++
 	cycle2 := apu.cycle
 	apu.stepTimer()
+	if apu.expansion != nil {
+		apu.expansion.StepAudio()
+	}
+	frameCounterRate := apu.console.timing.frameCounterRate
 	f1 := int(float64(cycle1) / frameCounterRate)
 	f2 := int(float64(cycle2) / frameCounterRate)
 	if f1 != f2 {
@@ -114,7 +162,7 @@ func (apu *APU) Step() {
 }
 
 func (apu *APU) sendSample() {
-	output := apu.filterChain.Step(apu.output())
+	output := apu.filterChain.Step(apu.output()) * apu.volume
 	select {
 	case apu.channel <- output:
 	default:
@@ -129,6 +177,9 @@ func (apu *APU) output() float32 {
 	d := apu.dmc.output()
 	pulseOut := pulseTable[p1+p2]
 	tndOut := tndTable[3*t+2*n+d]
+	if apu.expansion != nil {
+		return pulseOut + tndOut + apu.expansion.AudioOutput()
+	}
 	return pulseOut + tndOut
 }
 
@@ -625,6 +676,7 @@ type Noise struct {
 	envelopeValue   byte
 	envelopeVolume  byte
 	constantVolume  byte
+	periodTable     []uint16
 }
 
 func (n *Noise) Save(encoder *gob.Encoder) error {
@@ -674,7 +726,7 @@ func (n *Noise) writeControl(value byte) {
 
 func (n *Noise) writePeriod(value byte) {
 	n.mode = value&0x80 == 0x80
-	n.timerPeriod = noiseTable[value&0x0F]
+	n.timerPeriod = n.periodTable[value&0x0F]
 }
 
 func (n *Noise) writeLength(value byte) {
@@ -756,6 +808,7 @@ type DMC struct {
 	tickValue      byte
 	loop           bool
 	irq            bool
+	periodTable    []byte
 }
 
 func (d *DMC) Save(encoder *gob.Encoder) error {
@@ -793,7 +846,7 @@ func (d *DMC) Load(decoder *gob.Decoder) error {
 func (d *DMC) writeControl(value byte) {
 	d.irq = value&0x80 == 0x80
 	d.loop = value&0x40 == 0x40
-	d.tickPeriod = dmcTable[value&0x0F]
+	d.tickPeriod = d.periodTable[value&0x0F]
 }
 
 func (d *DMC) writeValue(value byte) {
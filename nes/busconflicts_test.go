@@ -0,0 +1,43 @@
+package nes
+
+import "testing"
+
+// TestBusConflicts writes $17 over a ROM byte of $05 on each discrete-logic
+// mapper. Submapper 2 latches the AND of the two, $05; submappers 0 and 1
+// latch the value written.
+func TestBusConflicts(t *testing.T) {
+	tests := []struct {
+		mapper    uint16
+		submapper byte
+		want      int // bank latched
+	}{
+		{2, 0, 7}, {2, 1, 7}, {2, 2, 5},
+		{3, 0, 3}, {3, 1, 3}, {3, 2, 1},
+		{7, 0, 7}, {7, 1, 7}, {7, 2, 5},
+	}
+	for _, test := range tests {
+		cartridge := &Cartridge{
+			PRG: make([]byte, 0x40000), CHR: make([]byte, 0x8000),
+			SRAM: make([]byte, 0x2000), Mapper: test.mapper,
+			Submapper: test.submapper}
+		cartridge.PRG[0] = 0x05
+		mapper, err := NewMapper(&Console{Cartridge: cartridge})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapper.Write(0x8000, 0x17)
+		var bank int
+		switch m := mapper.(type) {
+		case *Mapper2:
+			bank = m.prgBank1
+		case *Mapper3:
+			bank = m.chrBank
+		case *Mapper7:
+			bank = m.prgBank
+		}
+		if bank != test.want {
+			t.Errorf("mapper %d.%d latched bank %d, want %d",
+				test.mapper, test.submapper, bank, test.want)
+		}
+	}
+}
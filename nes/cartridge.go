@@ -1,19 +1,94 @@
 package nes
 
-import "encoding/gob"
+import (
+	"encoding/gob"
+	"io"
+	"os"
+)
 
 type Cartridge struct {
-	PRG     []byte // PRG-ROM banks
-	CHR     []byte // CHR-ROM banks
-	SRAM    []byte // Save RAM
-	Mapper  byte   // mapper type
-	Mirror  byte   // mirroring mode
-	Battery byte   // battery present
+	PRG     []byte   // PRG-ROM banks
+	CHR     []byte   // CHR-ROM banks
+	SRAM    []byte   // Save RAM
+	Trainer []byte   // 512-byte trainer loaded at $7000, if present
+	VRAM    []byte   // extra nametable RAM for four-screen mirroring
+	ChipRAM []byte   // RAM inside the mapper chip, battery-backed with SRAM
+	Disk    [][]byte // Famicom Disk System sides, as the drive reads them
+	NSF     *NSF     // music file details when playing an NSF
+	Board   string   // UNIF board name, resolved to a mapper by NewMapper
+	Mapper  uint16   // mapper type
+	Mirror  byte     // mirroring mode
+	Battery byte     // battery present
+	Region  Region   // television system the game was made for
+
+	// information only present in NES 2.0 headers
+	NES20           bool // header is in NES 2.0 format
+	Submapper       byte // mapper variant
+	PRGRAMSize      int  // volatile PRG-RAM size in bytes
+	PRGNVRAMSize    int  // battery-backed PRG-RAM size in bytes
+	CHRRAMSize      int  // volatile CHR-RAM size in bytes
+	CHRNVRAMSize    int  // battery-backed CHR-RAM size in bytes
+	ConsoleType     byte // 0: NES/Famicom; 1: Vs. System; 2: PlayChoice-10; 3+: extended
+	ExpansionDevice byte // default expansion device
+
+	originalDisk [][]byte // disk sides as loaded, to find what was written
+	sizesKnown   bool     // RAM sizes come from a NES 2.0 header or the game database
 }
 
-func NewCartridge(prg, chr []byte, mapper, mirror, battery byte) *Cartridge {
+func NewCartridge(prg, chr []byte, mapper uint16, mirror, battery byte) *Cartridge {
 	sram := make([]byte, 0x2000)
-	return &Cartridge{prg, chr, sram, mapper, mirror, battery}
+	cartridge := Cartridge{
+		PRG: prg, CHR: chr, SRAM: sram,
+		Mapper: mapper, Mirror: mirror, Battery: battery}
+	return &cartridge
+}
+
+// LoadFile reads a game image in any supported format, recognized by its
+// contents rather than its file extension
+func LoadFile(path string) (*Cartridge, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 16)
+	n, err := io.ReadFull(file, magic)
+	file.Close()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	switch {
+	case isFDSImage(magic[:n]):
+		return LoadFDSFile(path)
+	case isNSFFile(magic[:n]):
+		return LoadNSFFile(path)
+	case isUNIFFile(magic[:n]):
+		return LoadUNIFFile(path)
+	}
+	return LoadNESFile(path)
+}
+
+// allocateRAM sizes the PRG-RAM and CHR-RAM from the cartridge details.
+// Boards decode a full 8KB at $6000-$7FFF, so save RAM is never smaller,
+// and CHR-RAM is only provided when there is no CHR-ROM.
+func (cartridge *Cartridge) allocateRAM() {
+	if size := cartridge.PRGRAMSize + cartridge.PRGNVRAMSize; size > len(cartridge.SRAM) {
+		cartridge.SRAM = make([]byte, size)
+	}
+	if len(cartridge.CHR) == 0 {
+		size := cartridge.CHRRAMSize + cartridge.CHRNVRAMSize
+		if size < 8192 {
+			size = 8192
+		}
+		cartridge.CHR = make([]byte, size)
+	}
+}
+
+// loadTrainer copies the trainer into save RAM at $7000-$71FF, where it
+// would have been placed by the copier before the game started
+func (cartridge *Cartridge) loadTrainer() {
+	if len(cartridge.Trainer) > 0 && len(cartridge.SRAM) >= 0x1200 {
+		copy(cartridge.SRAM[0x1000:0x1200], cartridge.Trainer)
+	}
 }
 
 func (cartridge *Cartridge) Save(encoder *gob.Encoder) error {
@@ -21,6 +96,9 @@ func (cartridge *Cartridge) Save(encoder *gob.Encoder) error {
 	encoder.Encode(cartridge.CHR)
 	encoder.Encode(cartridge.SRAM)
 	encoder.Encode(cartridge.Mirror)
+	encoder.Encode(cartridge.VRAM)
+	encoder.Encode(cartridge.ChipRAM)
+	encoder.Encode(cartridge.Disk)
 	return nil
 }
 
@@ -29,5 +107,8 @@ func (cartridge *Cartridge) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&cartridge.CHR)
 	decoder.Decode(&cartridge.SRAM)
 	decoder.Decode(&cartridge.Mirror)
+	decoder.Decode(&cartridge.VRAM)
+	decoder.Decode(&cartridge.ChipRAM)
+	decoder.Decode(&cartridge.Disk)
 	return nil
 }
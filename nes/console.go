@@ -2,6 +2,7 @@ package nes
 
 import (
 	"encoding/gob"
+	"fmt"
 	"image"
 	"image/color"
 	"os"
@@ -9,18 +10,32 @@ import (
 )
 
 type Console struct {
-	CPU         *CPU
-	APU         *APU
-	PPU         *PPU
-	Cartridge   *Cartridge
-	Controller1 *Controller
-	Controller2 *Controller
-	Mapper      Mapper
-	RAM         []byte
+	CPU          *CPU
+	APU          *APU
+	PPU          *PPU
+	Cartridge    *Cartridge
+	Controller1  *Controller
+	Controller2  *Controller
+	Mapper       Mapper
+	RAM          []byte
+	region       Region
+	timing       *regionTiming
+	ppuPhase     int
+	openBus      byte            // last value on the CPU data bus
+	cycleWatcher CPUCycleWatcher // mapper clocked every CPU cycle, if any
+}
+
+// JamError reports a CPU halted by a KIL instruction
+type JamError struct {
+	PC uint16
+}
+
+func (e *JamError) Error() string {
+	return fmt.Sprintf("CPU jammed at $%04X", e.PC)
 }
 
 func NewConsole(path string) (*Console, error) {
-	cartridge, err := LoadNESFile(path)
+	cartridge, err := LoadFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -28,33 +43,135 @@ func NewConsole(path string) (*Console, error) {
 	controller1 := NewController()
 	controller2 := NewController()
 	console := Console{
-		nil, nil, nil, cartridge, controller1, controller2, nil, ram}
+		nil, nil, nil, cartridge, controller1, controller2, nil, ram,
+		RegionNTSC, &regionTimings[RegionNTSC], 0, 0, nil}
 	mapper, err := NewMapper(&console)
 	if err != nil {
 		return nil, err
 	}
 	console.Mapper = mapper
+	console.cycleWatcher, _ = mapper.(CPUCycleWatcher)
 	console.CPU = NewCPU(&console)
 	console.APU = NewAPU(&console)
 	console.PPU = NewPPU(&console)
+	console.SetRegion(cartridge.Region)
+	cartridge.loadTrainer()
 	return &console, nil
 }
 
+// Region returns the television system the console is emulating
+func (console *Console) Region() Region {
+	return console.region
+}
+
+// SetRegion switches the console between NTSC, PAL and Dendy timing
+func (console *Console) SetRegion(region Region) {
+	timing := &regionTimings[region]
+	console.APU.sampleRate *= timing.cpuFrequency / console.timing.cpuFrequency
+	console.APU.setTiming(timing)
+	console.region = region
+	console.timing = timing
+}
+
+// OpenBus returns the last value on the CPU data bus, which is what reads
+// of addresses nothing answers return
+func (console *Console) OpenBus() byte {
+	return console.openBus
+}
+
+// CPUFrequency returns the CPU clock rate of the console's region in Hz
+func (console *Console) CPUFrequency() float64 {
+	return console.timing.cpuFrequency
+}
+
+// Reset presses the reset button. RAM and most cartridge hardware keep their
+// state, while the CPU, PPU and APU run their reset sequences.
 func (console *Console) Reset() {
+	console.Mapper.Reset(false)
+	console.Controller1.Reset()
+	console.Controller2.Reset()
+	console.APU.Reset()
+	console.PPU.Reset()
 	console.CPU.Reset()
 }
 
+// PowerCycle turns the console off and on again, returning every component
+// and the cartridge registers to their power-on state. Battery-backed save
+// RAM is kept.
+func (console *Console) PowerCycle() {
+	for i := range console.RAM {
+		console.RAM[i] = 0
+	}
+	if console.Cartridge.Battery == 0 {
+		for i := range console.Cartridge.SRAM {
+			console.Cartridge.SRAM[i] = 0
+		}
+		for i := range console.Cartridge.ChipRAM {
+			console.Cartridge.ChipRAM[i] = 0
+		}
+	}
+	console.Cartridge.loadTrainer()
+	console.ppuPhase = 0
+	console.Mapper.Reset(true)
+	console.Controller1.Reset()
+	console.Controller2.Reset()
+	console.APU.PowerCycle()
+	console.PPU.PowerCycle()
+	console.CPU.PowerCycle()
+}
+
+// Fault returns a *JamError if the CPU has halted, or nil if it is running.
+// Only Reset recovers a jammed CPU.
+func (console *Console) Fault() error {
+	if console.CPU.Jammed() {
+		return &JamError{console.CPU.PC}
+	}
+	return nil
+}
+
+// EjectDisk takes the disk out of a Famicom Disk System, or puts it back.
+// It does nothing for cartridges.
+func (console *Console) EjectDisk() {
+	if mapper, ok := console.Mapper.(*Mapper20); ok {
+		mapper.EjectDisk()
+	}
+}
+
+// FlipDisk switches a Famicom Disk System to the next disk side. It does
+// nothing for cartridges.
+func (console *Console) FlipDisk() {
+	if mapper, ok := console.Mapper.(*Mapper20); ok {
+		mapper.FlipDisk()
+	}
+}
+
+// PlayTrack starts a track of an NSF file from the beginning. Tracks are
+// numbered from 0. It does nothing for cartridges.
+func (console *Console) PlayTrack(track int) {
+	if mapper, ok := console.Mapper.(*MapperNSF); ok {
+		mapper.track = track
+		console.PowerCycle()
+	}
+}
+
 func (console *Console) Step() int {
-	cpuCycles := console.CPU.Step()
-	ppuCycles := cpuCycles * 3
-	for i := 0; i < ppuCycles; i++ {
+	return console.CPU.Step()
+}
+
+// tick advances the PPU, mapper and APU by one CPU cycle. The CPU calls it
+// for every bus access so the rest of the console stays in lock step.
+func (console *Console) tick() {
+	// PAL runs 16 PPU cycles for every 5 CPU cycles instead of 15
+	console.ppuPhase += console.timing.ppuRatio
+	for console.ppuPhase >= 5 {
+		console.ppuPhase -= 5
 		console.PPU.Step()
 		console.Mapper.Step()
 	}
-	for i := 0; i < cpuCycles; i++ {
-		console.APU.Step()
+	if console.cycleWatcher != nil {
+		console.cycleWatcher.WatchCPUCycle()
 	}
-	return cpuCycles
+	console.APU.Step()
 }
 
 func (console *Console) StepFrame() int {
@@ -67,7 +184,7 @@ func (console *Console) StepFrame() int {
 }
 
 func (console *Console) StepSeconds(seconds float64) {
-	cycles := int(CPUFrequency * seconds)
+	cycles := int(console.CPUFrequency() * seconds)
 	for cycles > 0 {
 		cycles -= console.Step()
 	}
@@ -78,7 +195,7 @@ func (console *Console) Buffer() *image.RGBA {
 }
 
 func (console *Console) BackgroundColor() color.RGBA {
-	return Palette[console.PPU.readPalette(0)%64]
+	return console.PPU.paletteColor(0)
 }
 
 func (console *Console) SetButtons1(buttons [8]bool) {
@@ -93,10 +210,15 @@ func (console *Console) SetAudioChannel(channel chan float32) {
 	console.APU.channel = channel
 }
 
+// SetVolume scales the audio output, from 0 for silence to 1 for full volume
+func (console *Console) SetVolume(volume float32) {
+	console.APU.volume = volume
+}
+
 func (console *Console) SetAudioSampleRate(sampleRate float64) {
 	if sampleRate != 0 {
 		// Convert samples per second to cpu steps per sample
-		console.APU.sampleRate = CPUFrequency / sampleRate
+		console.APU.sampleRate = console.CPUFrequency() / sampleRate
 		// Initialize filters
 		console.APU.filterChain = FilterChain{
 			HighPassFilter(float32(sampleRate), 90),
@@ -123,6 +245,8 @@ func (console *Console) SaveState(filename string) error {
 
 func (console *Console) Save(encoder *gob.Encoder) error {
 	encoder.Encode(console.RAM)
+	encoder.Encode(console.ppuPhase)
+	encoder.Encode(console.openBus)
 	console.CPU.Save(encoder)
 	console.APU.Save(encoder)
 	console.PPU.Save(encoder)
@@ -143,6 +267,8 @@ func (console *Console) LoadState(filename string) error {
 
 func (console *Console) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&console.RAM)
+	decoder.Decode(&console.ppuPhase)
+	decoder.Decode(&console.openBus)
 	console.CPU.Load(decoder)
 	console.APU.Load(decoder)
 	console.PPU.Load(decoder)
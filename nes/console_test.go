@@ -0,0 +1,29 @@
+package nes
+
+import "testing"
+
+type cycleCounter int
+
+func (c *cycleCounter) WatchCPUCycle() {
+	*c++
+}
+
+// TestCPUCycleWatcher checks that mappers are clocked once per CPU cycle in
+// every region, although PAL runs 16 PPU cycles for every 5 CPU cycles
+func TestCPUCycleWatcher(t *testing.T) {
+	for _, region := range []Region{RegionNTSC, RegionPAL, RegionDendy} {
+		// an endless loop of NOPs
+		console := newTestConsole(t, testProgram(0xEA, 0x4C, 0x00, 0x80))
+		console.SetRegion(region)
+		var counter cycleCounter
+		console.cycleWatcher = &counter
+		cycles := console.CPU.Cycles
+		for i := 0; i < 1000; i++ {
+			console.Step()
+		}
+		if want := console.CPU.Cycles - cycles; uint64(counter) != want {
+			t.Errorf("%v: %d CPU cycle calls in %d cycles",
+				region, counter, want)
+		}
+	}
+}
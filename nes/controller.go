@@ -21,6 +21,12 @@ func NewController() *Controller {
 	return &Controller{}
 }
 
+// Reset clears the strobe latch and the shift register position
+func (c *Controller) Reset() {
+	c.index = 0
+	c.strobe = 0
+}
+
 func (c *Controller) SetButtons(buttons [8]bool) {
 	c.buttons = buttons
 }
@@ -55,43 +55,22 @@ var instructionModes = [256]byte{
 
 // instructionSizes indicates the size of each instruction in bytes
 var instructionSizes = [256]byte{
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	3, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	1, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	1, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 0, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 0, 3, 0, 0,
-	2, 2, 2, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-}
-
-// instructionCycles indicates the number of cycles used by each instruction,
-// not including conditional cycles
-var instructionCycles = [256]byte{
-	7, 6, 2, 8, 3, 3, 5, 5, 3, 2, 2, 2, 4, 4, 6, 6,
-	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
-	6, 6, 2, 8, 3, 3, 5, 5, 4, 2, 2, 2, 4, 4, 6, 6,
-	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
-	6, 6, 2, 8, 3, 3, 5, 5, 3, 2, 2, 2, 3, 4, 6, 6,
-	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
-	6, 6, 2, 8, 3, 3, 5, 5, 4, 2, 2, 2, 5, 4, 6, 6,
-	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
-	2, 6, 2, 6, 3, 3, 3, 3, 2, 2, 2, 2, 4, 4, 4, 4,
-	2, 6, 2, 6, 4, 4, 4, 4, 2, 5, 2, 5, 5, 5, 5, 5,
-	2, 6, 2, 6, 3, 3, 3, 3, 2, 2, 2, 2, 4, 4, 4, 4,
-	2, 5, 2, 5, 4, 4, 4, 4, 2, 4, 2, 4, 4, 4, 4, 4,
-	2, 6, 2, 8, 3, 3, 5, 5, 2, 2, 2, 2, 4, 4, 6, 6,
-	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
-	2, 6, 2, 8, 3, 3, 5, 5, 2, 2, 2, 2, 4, 4, 6, 6,
-	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	3, 2, 1, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	1, 2, 1, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	1, 2, 1, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	2, 2, 2, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	2, 2, 2, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	2, 2, 2, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	2, 2, 2, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
 }
 
 // instructionPageCycles indicates the number of cycles used by each
@@ -168,14 +147,19 @@ type CPU struct {
 	V         byte   // overflow flag
 	N         byte   // negative flag
 	interrupt byte   // interrupt type to perform
+	polled    byte   // interrupt seen at the start of the last cycle
 	stall     int    // number of cycles to stall
+	jammed    bool   // halted by a KIL instruction
+	dmaPage   byte   // page to copy on the pending OAM DMA
+	dmaActive bool   // an OAM DMA will run before the next instruction
+	console   *Console
 	table     [256]func(*stepInfo)
 }
 
 func NewCPU(console *Console) *CPU {
-	cpu := CPU{Memory: NewCPUMemory(console)}
+	cpu := CPU{Memory: NewCPUMemory(console), console: console}
 	cpu.createTable()
-	cpu.Reset()
+	cpu.PowerCycle()
 	return &cpu
 }
 
@@ -233,7 +217,11 @@ func (cpu *CPU) Save(encoder *gob.Encoder) error {
 	encoder.Encode(cpu.V)
 	encoder.Encode(cpu.N)
 	encoder.Encode(cpu.interrupt)
+	encoder.Encode(cpu.polled)
 	encoder.Encode(cpu.stall)
+	encoder.Encode(cpu.jammed)
+	encoder.Encode(cpu.dmaPage)
+	encoder.Encode(cpu.dmaActive)
 	return nil
 }
 
@@ -253,15 +241,41 @@ func (cpu *CPU) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&cpu.V)
 	decoder.Decode(&cpu.N)
 	decoder.Decode(&cpu.interrupt)
+	decoder.Decode(&cpu.polled)
 	decoder.Decode(&cpu.stall)
+	decoder.Decode(&cpu.jammed)
+	decoder.Decode(&cpu.dmaPage)
+	decoder.Decode(&cpu.dmaActive)
 	return nil
 }
 
-// Reset resets the CPU to its initial powerup state
+// Reset runs the CPU reset sequence: the registers keep their values, the
+// stack pointer drops by three as if the return address and flags had been
+// pushed, and interrupts are disabled
 func (cpu *CPU) Reset() {
 	cpu.PC = cpu.Read16(0xFFFC)
-	cpu.SP = 0xFD
-	cpu.SetFlags(0x24)
+	cpu.SP -= 3
+	cpu.I = 1
+	cpu.interrupt = interruptNone
+	cpu.polled = interruptNone
+	cpu.stall = 0
+	cpu.jammed = false
+	cpu.dmaActive = false
+}
+
+// PowerCycle puts the CPU into its power-on state
+func (cpu *CPU) PowerCycle() {
+	cpu.A = 0
+	cpu.X = 0
+	cpu.Y = 0
+	cpu.SP = 0
+	cpu.SetFlags(0x20)
+	cpu.Reset()
+}
+
+// Jammed returns true if a KIL instruction has halted the CPU
+func (cpu *CPU) Jammed() bool {
+	return cpu.jammed
 }
 
 // PrintInstruction prints the current CPU state
@@ -290,13 +304,53 @@ func pagesDiffer(a, b uint16) bool {
 	return a&0xFF00 != b&0xFF00
 }
 
-// addBranchCycles adds a cycle for taking a branch and adds another cycle
-// if the branch jumps to a new page
-func (cpu *CPU) addBranchCycles(info *stepInfo) {
+// tick advances the rest of the console by one CPU cycle. Interrupts are
+// polled at the start of each cycle, so the state seen at the end of an
+// instruction is the state at the end of its second-to-last cycle.
+func (cpu *CPU) tick() {
 	cpu.Cycles++
+	cpu.polled = cpu.interrupt
+	cpu.console.tick()
+}
+
+// read performs a CPU bus read cycle
+func (cpu *CPU) read(address uint16) byte {
+	cpu.tick()
+	return cpu.Read(address)
+}
+
+// write performs a CPU bus write cycle
+func (cpu *CPU) write(address uint16, value byte) {
+	cpu.tick()
+	cpu.Write(address, value)
+}
+
+// readModify reads the operand of a read-modify-write instruction, including
+// the dummy write of the unmodified value that the 6502 makes
+func (cpu *CPU) readModify(address uint16) byte {
+	value := cpu.read(address)
+	cpu.write(address, value)
+	return value
+}
+
+// fixupRead performs the read the 6502 makes from the partially computed
+// address while it carries into the high byte of an indexed address.
+// Instructions that write memory always make it, reads only when a page is
+// crossed.
+func (cpu *CPU) fixupRead(opcode byte, base, address uint16) {
+	if pagesDiffer(base, address) || instructionPageCycles[opcode] == 0 {
+		cpu.read(base&0xFF00 | address&0x00FF)
+	}
+}
+
+// takeBranch jumps to the branch target, performing the dummy reads for
+// taking a branch and for a branch that jumps to a new page
+func (cpu *CPU) takeBranch(info *stepInfo) {
+	cpu.read(info.pc)
 	if pagesDiffer(info.pc, info.address) {
-		cpu.Cycles++
+		cpu.read(info.pc&0xFF00 | info.address&0x00FF)
 	}
+	cpu.PC = info.address
 }
 
 func (cpu *CPU) compare(a, b byte) {
@@ -315,26 +369,39 @@ func (cpu *CPU) Read16(address uint16) uint16 {
 	return hi<<8 | lo
 }
 
+// read16 reads a double-word value using two bus read cycles
+func (cpu *CPU) read16(address uint16) uint16 {
+	lo := uint16(cpu.read(address))
+	hi := uint16(cpu.read(address + 1))
+	return hi<<8 | lo
+}
+
 // read16bug emulates a 6502 bug that caused the low byte to wrap without
 // incrementing the high byte
 func (cpu *CPU) read16bug(address uint16) uint16 {
 	a := address
 	b := (a & 0xFF00) | uint16(byte(a)+1)
-	lo := cpu.Read(a)
-	hi := cpu.Read(b)
+	lo := cpu.read(a)
+	hi := cpu.read(b)
 	return uint16(hi)<<8 | uint16(lo)
 }
 
 // push pushes a byte onto the stack
 func (cpu *CPU) push(value byte) {
-	cpu.Write(0x100|uint16(cpu.SP), value)
+	cpu.write(0x100|uint16(cpu.SP), value)
 	cpu.SP--
 }
 
 // pull pops a byte from the stack
 func (cpu *CPU) pull() byte {
 	cpu.SP++
-	return cpu.Read(0x100 | uint16(cpu.SP))
+	return cpu.read(0x100 | uint16(cpu.SP))
+}
+
+// peek performs the dummy stack read the 6502 makes before pulling or while
+// it saves the return address of a JSR
+func (cpu *CPU) peek() {
+	cpu.read(0x100 | uint16(cpu.SP))
 }
 
 // push16 pushes two bytes onto the stack
@@ -414,6 +481,13 @@ func (cpu *CPU) triggerIRQ() {
 	}
 }
 
+// triggerDMA causes an OAM DMA from the given page to occur before the next
+// instruction
+func (cpu *CPU) triggerDMA(page byte) {
+	cpu.dmaPage = page
+	cpu.dmaActive = true
+}
+
 // stepInfo contains information that the instruction functions use
 type stepInfo struct {
 	address uint16
@@ -425,94 +499,135 @@ type stepInfo struct {
 func (cpu *CPU) Step() int {
 	if cpu.stall > 0 {
 		cpu.stall--
+		cpu.tick()
+		return 1
+	}
+
+	// a jammed CPU stops fetching instructions and ignores interrupts, but
+	// the clock keeps running so the PPU and APU continue to be stepped
+	if cpu.jammed {
+		cpu.tick()
 		return 1
 	}
 
 	cycles := cpu.Cycles
 
-	switch cpu.interrupt {
-	case interruptNMI:
-		cpu.nmi()
-	case interruptIRQ:
-		cpu.irq()
+	if cpu.dmaActive {
+		cpu.dma()
 	}
-	cpu.interrupt = interruptNone
 
-	opcode := cpu.Read(cpu.PC)
+	if cpu.polled != interruptNone {
+		switch cpu.interrupt {
+		case interruptNMI:
+			cpu.nmi()
+		case interruptIRQ:
+			cpu.irq()
+		}
+		cpu.interrupt = interruptNone
+	}
+
+	opcode := cpu.read(cpu.PC)
 	mode := instructionModes[opcode]
 
 	var address uint16
-	var pageCrossed bool
 	switch mode {
 	case modeAbsolute:
-		address = cpu.Read16(cpu.PC + 1)
+		if opcode == 0x20 {
+			// JSR reads the high byte last, after pushing the return address
+			address = uint16(cpu.read(cpu.PC + 1))
+		} else {
+			address = cpu.read16(cpu.PC + 1)
+		}
 	case modeAbsoluteX:
-		address = cpu.Read16(cpu.PC+1) + uint16(cpu.X)
-		pageCrossed = pagesDiffer(address-uint16(cpu.X), address)
+		base := cpu.read16(cpu.PC + 1)
+		address = base + uint16(cpu.X)
+		cpu.fixupRead(opcode, base, address)
 	case modeAbsoluteY:
-		address = cpu.Read16(cpu.PC+1) + uint16(cpu.Y)
-		pageCrossed = pagesDiffer(address-uint16(cpu.Y), address)
+		base := cpu.read16(cpu.PC + 1)
+		address = base + uint16(cpu.Y)
+		cpu.fixupRead(opcode, base, address)
 	case modeAccumulator:
+		cpu.read(cpu.PC + 1)
 		address = 0
 	case modeImmediate:
 		address = cpu.PC + 1
 	case modeImplied:
+		cpu.read(cpu.PC + 1)
 		address = 0
 	case modeIndexedIndirect:
-		address = cpu.read16bug(uint16(cpu.Read(cpu.PC+1) + cpu.X))
+		pointer := cpu.read(cpu.PC + 1)
+		cpu.read(uint16(pointer))
+		address = cpu.read16bug(uint16(pointer + cpu.X))
 	case modeIndirect:
-		address = cpu.read16bug(cpu.Read16(cpu.PC + 1))
+		address = cpu.read16bug(cpu.read16(cpu.PC + 1))
 	case modeIndirectIndexed:
-		address = cpu.read16bug(uint16(cpu.Read(cpu.PC+1))) + uint16(cpu.Y)
-		pageCrossed = pagesDiffer(address-uint16(cpu.Y), address)
+		base := cpu.read16bug(uint16(cpu.read(cpu.PC + 1)))
+		address = base + uint16(cpu.Y)
+		cpu.fixupRead(opcode, base, address)
 	case modeRelative:
-		offset := uint16(cpu.Read(cpu.PC + 1))
+		offset := uint16(cpu.read(cpu.PC + 1))
 		if offset < 0x80 {
 			address = cpu.PC + 2 + offset
 		} else {
 			address = cpu.PC + 2 + offset - 0x100
 		}
 	case modeZeroPage:
-		address = uint16(cpu.Read(cpu.PC + 1))
+		address = uint16(cpu.read(cpu.PC + 1))
 	case modeZeroPageX:
-		address = uint16(cpu.Read(cpu.PC+1)+cpu.X) & 0xff
+		base := cpu.read(cpu.PC + 1)
+		cpu.read(uint16(base))
+		address = uint16(base + cpu.X)
 	case modeZeroPageY:
-		address = uint16(cpu.Read(cpu.PC+1)+cpu.Y) & 0xff
+		base := cpu.read(cpu.PC + 1)
+		cpu.read(uint16(base))
+		address = uint16(base + cpu.Y)
 	}
 
 	cpu.PC += uint16(instructionSizes[opcode])
-	cpu.Cycles += uint64(instructionCycles[opcode])
-	if pageCrossed {
-		cpu.Cycles += uint64(instructionPageCycles[opcode])
-	}
 	info := &stepInfo{address, cpu.PC, mode}
 	cpu.table[opcode](info)
 
 	return int(cpu.Cycles - cycles)
 }
 
+// dma copies a page of CPU memory to OAM through $2004, taking 513 cycles or
+// 514 when it starts on an odd cycle
+func (cpu *CPU) dma() {
+	cpu.dmaActive = false
+	cpu.tick()
+	if cpu.Cycles%2 == 1 {
+		cpu.tick()
+	}
+	address := uint16(cpu.dmaPage) << 8
+	for i := 0; i < 256; i++ {
+		cpu.write(0x2004, cpu.read(address))
+		address++
+	}
+}
+
 // NMI - Non-Maskable Interrupt
 func (cpu *CPU) nmi() {
+	cpu.read(cpu.PC)
+	cpu.read(cpu.PC)
 	cpu.push16(cpu.PC)
-	cpu.php(nil)
-	cpu.PC = cpu.Read16(0xFFFA)
+	cpu.push(cpu.Flags() &^ 0x10)
 	cpu.I = 1
-	cpu.Cycles += 7
+	cpu.PC = cpu.read16(0xFFFA)
 }
 
 // IRQ - IRQ Interrupt
 func (cpu *CPU) irq() {
+	cpu.read(cpu.PC)
+	cpu.read(cpu.PC)
 	cpu.push16(cpu.PC)
-	cpu.php(nil)
-	cpu.PC = cpu.Read16(0xFFFE)
+	cpu.push(cpu.Flags() &^ 0x10)
 	cpu.I = 1
-	cpu.Cycles += 7
+	cpu.PC = cpu.read16(0xFFFE)
 }
 
-// ADC - Add with Carry
-func (cpu *CPU) adc(info *stepInfo) {
+// add adds a value and the carry flag to the accumulator
+func (cpu *CPU) add(b byte) {
 	a := cpu.A
-	b := cpu.Read(info.address)
 	c := cpu.C
 	cpu.A = a + b + c
 	cpu.setZN(cpu.A)
@@ -528,9 +643,33 @@ func (cpu *CPU) adc(info *stepInfo) {
 	}
 }
 
+// subtract subtracts a value and the borrow (inverted carry) from the
+// accumulator
+func (cpu *CPU) subtract(b byte) {
+	a := cpu.A
+	c := cpu.C
+	cpu.A = a - b - (1 - c)
+	cpu.setZN(cpu.A)
+	if int(a)-int(b)-int(1-c) >= 0 {
+		cpu.C = 1
+	} else {
+		cpu.C = 0
+	}
+	if (a^b)&0x80 != 0 && (a^cpu.A)&0x80 != 0 {
+		cpu.V = 1
+	} else {
+		cpu.V = 0
+	}
+}
+
+// ADC - Add with Carry
+func (cpu *CPU) adc(info *stepInfo) {
+	cpu.add(cpu.read(info.address))
+}
+
 // AND - Logical AND
 func (cpu *CPU) and(info *stepInfo) {
-	cpu.A = cpu.A & cpu.Read(info.address)
+	cpu.A = cpu.A & cpu.read(info.address)
 	cpu.setZN(cpu.A)
 }
 
@@ -541,10 +680,10 @@ func (cpu *CPU) asl(info *stepInfo) {
 		cpu.A <<= 1
 		cpu.setZN(cpu.A)
 	} else {
-		value := cpu.Read(info.address)
+		value := cpu.readModify(info.address)
 		cpu.C = (value >> 7) & 1
 		value <<= 1
-		cpu.Write(info.address, value)
+		cpu.write(info.address, value)
 		cpu.setZN(value)
 	}
 }
@@ -552,30 +691,27 @@ func (cpu *CPU) asl(info *stepInfo) {
 // BCC - Branch if Carry Clear
 func (cpu *CPU) bcc(info *stepInfo) {
 	if cpu.C == 0 {
-		cpu.PC = info.address
-		cpu.addBranchCycles(info)
+		cpu.takeBranch(info)
 	}
 }
 
 // BCS - Branch if Carry Set
 func (cpu *CPU) bcs(info *stepInfo) {
 	if cpu.C != 0 {
-		cpu.PC = info.address
-		cpu.addBranchCycles(info)
+		cpu.takeBranch(info)
 	}
 }
 
 // BEQ - Branch if Equal
 func (cpu *CPU) beq(info *stepInfo) {
 	if cpu.Z != 0 {
-		cpu.PC = info.address
-		cpu.addBranchCycles(info)
+		cpu.takeBranch(info)
 	}
 }
 
 // BIT - Bit Test
 func (cpu *CPU) bit(info *stepInfo) {
-	value := cpu.Read(info.address)
+	value := cpu.read(info.address)
 	cpu.V = (value >> 6) & 1
 	cpu.setZ(value & cpu.A)
 	cpu.setN(value)
@@ -584,48 +720,43 @@ func (cpu *CPU) bit(info *stepInfo) {
 // BMI - Branch if Minus
 func (cpu *CPU) bmi(info *stepInfo) {
 	if cpu.N != 0 {
-		cpu.PC = info.address
-		cpu.addBranchCycles(info)
+		cpu.takeBranch(info)
 	}
 }
 
 // BNE - Branch if Not Equal
 func (cpu *CPU) bne(info *stepInfo) {
 	if cpu.Z == 0 {
-		cpu.PC = info.address
-		cpu.addBranchCycles(info)
+		cpu.takeBranch(info)
 	}
 }
 
 // BPL - Branch if Positive
 func (cpu *CPU) bpl(info *stepInfo) {
 	if cpu.N == 0 {
-		cpu.PC = info.address
-		cpu.addBranchCycles(info)
+		cpu.takeBranch(info)
 	}
 }
 
 // BRK - Force Interrupt
 func (cpu *CPU) brk(info *stepInfo) {
 	cpu.push16(cpu.PC)
-	cpu.php(info)
+	cpu.push(cpu.Flags() | 0x10)
 	cpu.sei(info)
-	cpu.PC = cpu.Read16(0xFFFE)
+	cpu.PC = cpu.read16(0xFFFE)
 }
 
 // BVC - Branch if Overflow Clear
 func (cpu *CPU) bvc(info *stepInfo) {
 	if cpu.V == 0 {
-		cpu.PC = info.address
-		cpu.addBranchCycles(info)
+		cpu.takeBranch(info)
 	}
 }
 
 // BVS - Branch if Overflow Set
 func (cpu *CPU) bvs(info *stepInfo) {
 	if cpu.V != 0 {
-		cpu.PC = info.address
-		cpu.addBranchCycles(info)
+		cpu.takeBranch(info)
 	}
 }
 
@@ -651,26 +782,26 @@ func (cpu *CPU) clv(info *stepInfo) {
 
 // CMP - Compare
 func (cpu *CPU) cmp(info *stepInfo) {
-	value := cpu.Read(info.address)
+	value := cpu.read(info.address)
 	cpu.compare(cpu.A, value)
 }
 
 // CPX - Compare X Register
 func (cpu *CPU) cpx(info *stepInfo) {
-	value := cpu.Read(info.address)
+	value := cpu.read(info.address)
 	cpu.compare(cpu.X, value)
 }
 
 // CPY - Compare Y Register
 func (cpu *CPU) cpy(info *stepInfo) {
-	value := cpu.Read(info.address)
+	value := cpu.read(info.address)
 	cpu.compare(cpu.Y, value)
 }
 
 // DEC - Decrement Memory
 func (cpu *CPU) dec(info *stepInfo) {
-	value := cpu.Read(info.address) - 1
-	cpu.Write(info.address, value)
+	value := cpu.readModify(info.address) - 1
+	cpu.write(info.address, value)
 	cpu.setZN(value)
 }
 
@@ -688,14 +819,14 @@ func (cpu *CPU) dey(info *stepInfo) {
 
 // EOR - Exclusive OR
 func (cpu *CPU) eor(info *stepInfo) {
-	cpu.A = cpu.A ^ cpu.Read(info.address)
+	cpu.A = cpu.A ^ cpu.read(info.address)
 	cpu.setZN(cpu.A)
 }
 
 // INC - Increment Memory
 func (cpu *CPU) inc(info *stepInfo) {
-	value := cpu.Read(info.address) + 1
-	cpu.Write(info.address, value)
+	value := cpu.readModify(info.address) + 1
+	cpu.write(info.address, value)
 	cpu.setZN(value)
 }
 
@@ -716,27 +847,29 @@ func (cpu *CPU) jmp(info *stepInfo) {
 	cpu.PC = info.address
 }
 
-// JSR - Jump to Subroutine
+// JSR - Jump to Subroutine. Step has only fetched the low byte of the
+// address; the high byte comes after the return address is pushed.
 func (cpu *CPU) jsr(info *stepInfo) {
+	cpu.peek()
 	cpu.push16(cpu.PC - 1)
-	cpu.PC = info.address
+	cpu.PC = info.address | uint16(cpu.read(cpu.PC-1))<<8
 }
 
 // LDA - Load Accumulator
 func (cpu *CPU) lda(info *stepInfo) {
-	cpu.A = cpu.Read(info.address)
+	cpu.A = cpu.read(info.address)
 	cpu.setZN(cpu.A)
 }
 
 // LDX - Load X Register
 func (cpu *CPU) ldx(info *stepInfo) {
-	cpu.X = cpu.Read(info.address)
+	cpu.X = cpu.read(info.address)
 	cpu.setZN(cpu.X)
 }
 
 // LDY - Load Y Register
 func (cpu *CPU) ldy(info *stepInfo) {
-	cpu.Y = cpu.Read(info.address)
+	cpu.Y = cpu.read(info.address)
 	cpu.setZN(cpu.Y)
 }
 
@@ -747,21 +880,25 @@ func (cpu *CPU) lsr(info *stepInfo) {
 		cpu.A >>= 1
 		cpu.setZN(cpu.A)
 	} else {
-		value := cpu.Read(info.address)
+		value := cpu.readModify(info.address)
 		cpu.C = value & 1
 		value >>= 1
-		cpu.Write(info.address, value)
+		cpu.write(info.address, value)
 		cpu.setZN(value)
 	}
 }
 
 // NOP - No Operation
 func (cpu *CPU) nop(info *stepInfo) {
+	// the unofficial NOPs with an operand still read it
+	if info.mode != modeImplied {
+		cpu.read(info.address)
+	}
 }
 
 // ORA - Logical Inclusive OR
 func (cpu *CPU) ora(info *stepInfo) {
-	cpu.A = cpu.A | cpu.Read(info.address)
+	cpu.A = cpu.A | cpu.read(info.address)
 	cpu.setZN(cpu.A)
 }
 
@@ -777,12 +914,14 @@ func (cpu *CPU) php(info *stepInfo) {
 
 // PLA - Pull Accumulator
 func (cpu *CPU) pla(info *stepInfo) {
+	cpu.peek()
 	cpu.A = cpu.pull()
 	cpu.setZN(cpu.A)
 }
 
 // PLP - Pull Processor Status
 func (cpu *CPU) plp(info *stepInfo) {
+	cpu.peek()
 	cpu.SetFlags(cpu.pull()&0xEF | 0x20)
 }
 
@@ -795,10 +934,10 @@ func (cpu *CPU) rol(info *stepInfo) {
 		cpu.setZN(cpu.A)
 	} else {
 		c := cpu.C
-		value := cpu.Read(info.address)
+		value := cpu.readModify(info.address)
 		cpu.C = (value >> 7) & 1
 		value = (value << 1) | c
-		cpu.Write(info.address, value)
+		cpu.write(info.address, value)
 		cpu.setZN(value)
 	}
 }
@@ -812,42 +951,32 @@ func (cpu *CPU) ror(info *stepInfo) {
 		cpu.setZN(cpu.A)
 	} else {
 		c := cpu.C
-		value := cpu.Read(info.address)
+		value := cpu.readModify(info.address)
 		cpu.C = value & 1
 		value = (value >> 1) | (c << 7)
-		cpu.Write(info.address, value)
+		cpu.write(info.address, value)
 		cpu.setZN(value)
 	}
 }
 
 // RTI - Return from Interrupt
 func (cpu *CPU) rti(info *stepInfo) {
+	cpu.peek()
 	cpu.SetFlags(cpu.pull()&0xEF | 0x20)
 	cpu.PC = cpu.pull16()
 }
 
 // RTS - Return from Subroutine
 func (cpu *CPU) rts(info *stepInfo) {
-	cpu.PC = cpu.pull16() + 1
+	cpu.peek()
+	cpu.PC = cpu.pull16()
+	cpu.read(cpu.PC)
+	cpu.PC++
 }
 
 // SBC - Subtract with Carry
 func (cpu *CPU) sbc(info *stepInfo) {
-	a := cpu.A
-	b := cpu.Read(info.address)
-	c := cpu.C
-	cpu.A = a - b - (1 - c)
-	cpu.setZN(cpu.A)
-	if int(a)-int(b)-int(1-c) >= 0 {
-		cpu.C = 1
-	} else {
-		cpu.C = 0
-	}
-	if (a^b)&0x80 != 0 && (a^cpu.A)&0x80 != 0 {
-		cpu.V = 1
-	} else {
-		cpu.V = 0
-	}
+	cpu.subtract(cpu.read(info.address))
 }
 
 // SEC - Set Carry Flag
@@ -867,17 +996,17 @@ func (cpu *CPU) sei(info *stepInfo) {
 
 // STA - Store Accumulator
 func (cpu *CPU) sta(info *stepInfo) {
-	cpu.Write(info.address, cpu.A)
+	cpu.write(info.address, cpu.A)
 }
 
 // STX - Store X Register
 func (cpu *CPU) stx(info *stepInfo) {
-	cpu.Write(info.address, cpu.X)
+	cpu.write(info.address, cpu.X)
 }
 
 // STY - Store Y Register
 func (cpu *CPU) sty(info *stepInfo) {
-	cpu.Write(info.address, cpu.Y)
+	cpu.write(info.address, cpu.Y)
 }
 
 // TAX - Transfer Accumulator to X
@@ -917,59 +1046,162 @@ func (cpu *CPU) tya(info *stepInfo) {
 
 // illegal opcodes below
 
+// unstableStore emulates the SHX, SHY, AHX and TAS store quirk: the value
+// written is ANDed with the high byte of the base address plus one, and if
+// indexing crossed a page the result also replaces the high address byte
+func (cpu *CPU) unstableStore(info *stepInfo, value byte, index byte) {
+	base := info.address - uint16(index)
+	value &= byte(base>>8) + 1
+	address := info.address
+	if pagesDiffer(base, address) {
+		address = uint16(value)<<8 | address&0xFF
+	}
+	cpu.write(address, value)
+}
+
+// AHX - Store A AND X AND (High Byte + 1)
 func (cpu *CPU) ahx(info *stepInfo) {
+	cpu.unstableStore(info, cpu.A&cpu.X, cpu.Y)
 }
 
+// ALR - AND then Logical Shift Right
 func (cpu *CPU) alr(info *stepInfo) {
+	cpu.A &= cpu.read(info.address)
+	cpu.C = cpu.A & 1
+	cpu.A >>= 1
+	cpu.setZN(cpu.A)
 }
 
+// ANC - AND then Copy Negative to Carry
 func (cpu *CPU) anc(info *stepInfo) {
+	cpu.A &= cpu.read(info.address)
+	cpu.setZN(cpu.A)
+	cpu.C = cpu.N
 }
 
+// ARR - AND then Rotate Right
 func (cpu *CPU) arr(info *stepInfo) {
+	cpu.A &= cpu.read(info.address)
+	cpu.A = (cpu.A >> 1) | (cpu.C << 7)
+	cpu.setZN(cpu.A)
+	cpu.C = (cpu.A >> 6) & 1
+	cpu.V = cpu.C ^ ((cpu.A >> 5) & 1)
 }
 
+// AXS - Store (A AND X) minus operand in X
 func (cpu *CPU) axs(info *stepInfo) {
+	value := cpu.read(info.address)
+	ax := cpu.A & cpu.X
+	cpu.X = ax - value
+	cpu.setZN(cpu.X)
+	if ax >= value {
+		cpu.C = 1
+	} else {
+		cpu.C = 0
+	}
 }
 
+// DCP - Decrement Memory then Compare
 func (cpu *CPU) dcp(info *stepInfo) {
+	value := cpu.readModify(info.address) - 1
+	cpu.write(info.address, value)
+	cpu.compare(cpu.A, value)
 }
 
+// ISC - Increment Memory then Subtract with Carry
 func (cpu *CPU) isc(info *stepInfo) {
+	value := cpu.readModify(info.address) + 1
+	cpu.write(info.address, value)
+	cpu.subtract(value)
 }
 
+// KIL - Halt the CPU until reset
 func (cpu *CPU) kil(info *stepInfo) {
+	cpu.PC = info.pc - 1
+	cpu.jammed = true
 }
 
+// LAS - Load A, X and SP with Memory AND SP
 func (cpu *CPU) las(info *stepInfo) {
+	value := cpu.read(info.address) & cpu.SP
+	cpu.A = value
+	cpu.X = value
+	cpu.SP = value
+	cpu.setZN(value)
 }
 
+// LAX - Load Accumulator and X Register
 func (cpu *CPU) lax(info *stepInfo) {
+	value := cpu.read(info.address)
+	cpu.A = value
+	cpu.X = value
+	cpu.setZN(value)
 }
 
+// RLA - Rotate Left then AND
 func (cpu *CPU) rla(info *stepInfo) {
+	c := cpu.C
+	value := cpu.readModify(info.address)
+	cpu.C = (value >> 7) & 1
+	value = (value << 1) | c
+	cpu.write(info.address, value)
+	cpu.A &= value
+	cpu.setZN(cpu.A)
 }
 
+// RRA - Rotate Right then Add with Carry
 func (cpu *CPU) rra(info *stepInfo) {
+	c := cpu.C
+	value := cpu.readModify(info.address)
+	cpu.C = value & 1
+	value = (value >> 1) | (c << 7)
+	cpu.write(info.address, value)
+	cpu.add(value)
 }
 
+// SAX - Store A AND X
 func (cpu *CPU) sax(info *stepInfo) {
+	cpu.write(info.address, cpu.A&cpu.X)
 }
 
+// SHX - Store X AND (High Byte + 1)
 func (cpu *CPU) shx(info *stepInfo) {
+	cpu.unstableStore(info, cpu.X, cpu.Y)
 }
 
+// SHY - Store Y AND (High Byte + 1)
 func (cpu *CPU) shy(info *stepInfo) {
+	cpu.unstableStore(info, cpu.Y, cpu.X)
 }
 
+// SLO - Arithmetic Shift Left then Logical Inclusive OR
 func (cpu *CPU) slo(info *stepInfo) {
+	value := cpu.readModify(info.address)
+	cpu.C = (value >> 7) & 1
+	value <<= 1
+	cpu.write(info.address, value)
+	cpu.A |= value
+	cpu.setZN(cpu.A)
 }
 
+// SRE - Logical Shift Right then Exclusive OR
 func (cpu *CPU) sre(info *stepInfo) {
+	value := cpu.readModify(info.address)
+	cpu.C = value & 1
+	value >>= 1
+	cpu.write(info.address, value)
+	cpu.A ^= value
+	cpu.setZN(cpu.A)
 }
 
+// TAS - Transfer A AND X to Stack Pointer, then store as AHX
 func (cpu *CPU) tas(info *stepInfo) {
+	cpu.SP = cpu.A & cpu.X
+	cpu.unstableStore(info, cpu.SP, cpu.Y)
 }
 
+// XAA - Transfer X to A then AND, using the common $EE "magic" constant
 func (cpu *CPU) xaa(info *stepInfo) {
+	cpu.A = (cpu.A | 0xEE) & cpu.X & cpu.read(info.address)
+	cpu.setZN(cpu.A)
 }
@@ -0,0 +1,415 @@
+package nes
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestConsole returns a console running an NROM cartridge with the given
+// 32KB PRG-ROM, reset to the address in its reset vector
+func newTestConsole(t *testing.T, prg []byte) *Console {
+	dir, err := ioutil.TempDir("", "nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rom := make([]byte, 16)
+	copy(rom, []byte{'N', 'E', 'S', 0x1A, byte(len(prg) / 0x4000), 1})
+	rom = append(rom, prg...)
+	rom = append(rom, make([]byte, 0x2000)...)
+	path := filepath.Join(dir, "test.nes")
+	if err := ioutil.WriteFile(path, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	console, err := NewConsole(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return console
+}
+
+// testProgram returns a 32KB PRG-ROM with code at $8000 and the reset
+// vector pointing to it
+func testProgram(code ...byte) []byte {
+	prg := make([]byte, 0x8000)
+	copy(prg, code)
+	prg[0x7FFC] = 0x00
+	prg[0x7FFD] = 0x80
+	return prg
+}
+
+// TestNestest runs nestest.nes in automation mode from $C000 and compares
+// the CPU state before every instruction with nestest.log, which covers the
+// official opcodes and, from $C6BD on, the unofficial ones. The ROM and log
+// are not distributed with the emulator; copy them into nes/testdata.
+// http://www.qmtpro.com/~nes/misc/nestest.txt
+func TestNestest(t *testing.T) {
+	rom := filepath.Join("testdata", "nestest.nes")
+	logPath := filepath.Join("testdata", "nestest.log")
+	if _, err := os.Stat(rom); err != nil {
+		t.Skip("testdata/nestest.nes not found")
+	}
+	file, err := os.Open(logPath)
+	if err != nil {
+		t.Skip("testdata/nestest.log not found")
+	}
+	defer file.Close()
+
+	console, err := NewConsole(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := console.CPU
+	cpu.PC = 0xC000
+	cpu.Cycles = 7
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	unofficial := false
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		want, err := parseNestestLine(text)
+		if err != nil {
+			t.Fatalf("nestest.log line %d: %v", line, err)
+		}
+		got := nestestState{
+			cpu.PC, cpu.A, cpu.X, cpu.Y, cpu.Flags(), cpu.SP, cpu.Cycles}
+		if got != want {
+			t.Fatalf("nestest.log line %d:\n got %s\nwant %s\n%s",
+				line, got, want, text)
+		}
+		if cpu.PC == 0xC6BD {
+			unofficial = true
+		}
+		cpu.Step()
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !unofficial {
+		t.Error("nestest.log never reached the unofficial opcode tests")
+	}
+	// automation mode leaves the error codes in $02 and $03
+	if console.RAM[2] != 0 || console.RAM[3] != 0 {
+		t.Errorf("nestest error codes $%02X $%02X", console.RAM[2], console.RAM[3])
+	}
+}
+
+type nestestState struct {
+	PC         uint16
+	A, X, Y, P byte
+	SP         byte
+	Cycles     uint64
+}
+
+func (s nestestState) String() string {
+	return fmt.Sprintf("%04X A:%02X X:%02X Y:%02X P:%02X SP:%02X CYC:%d",
+		s.PC, s.A, s.X, s.Y, s.P, s.SP, s.Cycles)
+}
+
+// parseNestestLine reads the program counter, registers and cycle count
+// from a line of nestest.log, such as
+// C000  4C F5 C5  JMP $C5F5     A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7
+func parseNestestLine(text string) (nestestState, error) {
+	var s nestestState
+	pc, err := strconv.ParseUint(text[:4], 16, 16)
+	if err != nil {
+		return s, err
+	}
+	s.PC = uint16(pc)
+	registers := map[string]*byte{
+		"A:": &s.A, "X:": &s.X, "Y:": &s.Y, "P:": &s.P, "SP:": &s.SP}
+	i := strings.LastIndex(text, " A:")
+	if i < 0 {
+		return s, strconv.ErrSyntax
+	}
+	for _, field := range strings.Fields(text[i:]) {
+		for prefix, register := range registers {
+			if strings.HasPrefix(field, prefix) {
+				value, err := strconv.ParseUint(field[len(prefix):], 16, 8)
+				if err != nil {
+					return s, err
+				}
+				*register = byte(value)
+			}
+		}
+		if strings.HasPrefix(field, "CYC:") {
+			value, err := strconv.ParseUint(field[4:], 10, 64)
+			if err != nil {
+				return s, err
+			}
+			s.Cycles = value
+		}
+	}
+	return s, nil
+}
+
+// TestUnstableStores checks that SHX, SHY, AHX and TAS AND the stored value
+// with the high byte of the base address plus one, and that when indexing
+// crosses a page the value also replaces the high byte of the address
+func TestUnstableStores(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    []byte
+		a, x, y byte
+		address uint16
+		value   byte
+		sp      byte // expected stack pointer, if non-zero
+	}{
+		{"SHY abs,X", []byte{0x9C, 0xFA, 0x06}, 0, 0x05, 0xFF, 0x06FF, 0x07, 0},
+		{"SHX abs,Y", []byte{0x9E, 0xFA, 0x06}, 0, 0xFF, 0x05, 0x06FF, 0x07, 0},
+		{"AHX abs,Y", []byte{0x9F, 0xFA, 0x06}, 0xFD, 0xF7, 0x05, 0x06FF, 0x05, 0},
+		{"AHX (zp),Y", []byte{0x93, 0x10}, 0xFF, 0xFF, 0x05, 0x06FF, 0x07, 0},
+		{"TAS abs,Y", []byte{0x9B, 0xFA, 0x06}, 0xF3, 0x3F, 0x05, 0x06FF, 0x03, 0x33},
+		// crossing from $02FE to page 3 stores $05 & $03 at $0102
+		{"SHY abs,X page cross", []byte{0x9C, 0xFE, 0x02}, 0, 0x04, 0x05, 0x0102, 0x01, 0},
+		{"SHX abs,Y page cross", []byte{0x9E, 0xFE, 0x02}, 0, 0x05, 0x04, 0x0102, 0x01, 0},
+	}
+	for _, test := range tests {
+		console := newTestConsole(t, testProgram(test.code...))
+		console.RAM[0x10] = 0xFA
+		console.RAM[0x11] = 0x06
+		cpu := console.CPU
+		cpu.A, cpu.X, cpu.Y = test.a, test.x, test.y
+		cpu.Step()
+		if got := console.RAM[test.address]; got != test.value {
+			t.Errorf("%s: $%04X = $%02X, want $%02X",
+				test.name, test.address, got, test.value)
+		}
+		if test.sp != 0 && cpu.SP != test.sp {
+			t.Errorf("%s: SP = $%02X, want $%02X", test.name, cpu.SP, test.sp)
+		}
+	}
+}
+
+// TestUnofficialOpcodes runs one of each unofficial instruction and checks
+// its result, flags, length and cycle count
+func TestUnofficialOpcodes(t *testing.T) {
+	type result struct {
+		a, x, p, m byte
+		sp         byte // expected stack pointer, if non-zero
+		cycles     uint64
+	}
+	tests := []struct {
+		name          string
+		code          []byte
+		a, x, y, p, m byte // registers, flags and the byte at $0010
+		want          result
+	}{
+		{"ANC #", []byte{0x0B, 0x81}, 0xC3, 0, 0, 0x24, 0, result{0x81, 0, 0xA5, 0, 0, 2}},
+		{"ALR #", []byte{0x4B, 0xFF}, 0x03, 0, 0, 0x24, 0, result{0x01, 0, 0x25, 0, 0, 2}},
+		{"ARR # carry", []byte{0x6B, 0xFF}, 0xC0, 0, 0, 0x25, 0, result{0xE0, 0, 0xA5, 0, 0, 2}},
+		{"ARR # overflow", []byte{0x6B, 0xFF}, 0x80, 0, 0, 0x24, 0, result{0x40, 0, 0x65, 0, 0, 2}},
+		{"AXS #", []byte{0xCB, 0x02}, 0x0F, 0x07, 0, 0x24, 0, result{0x0F, 0x05, 0x25, 0, 0, 2}},
+		{"AXS # borrow", []byte{0xCB, 0x02}, 0xFF, 0x01, 0, 0x25, 0, result{0xFF, 0xFF, 0xA4, 0, 0, 2}},
+		{"LAX zp", []byte{0xA7, 0x10}, 0, 0, 0, 0x24, 0x80, result{0x80, 0x80, 0xA4, 0x80, 0, 3}},
+		{"SAX zp", []byte{0x87, 0x10}, 0xF0, 0x3C, 0, 0x24, 0, result{0xF0, 0x3C, 0x24, 0x30, 0, 3}},
+		{"DCP zp", []byte{0xC7, 0x10}, 0x40, 0, 0, 0x24, 0x41, result{0x40, 0, 0x27, 0x40, 0, 5}},
+		{"ISC zp", []byte{0xE7, 0x10}, 0x20, 0, 0, 0x25, 0x0F, result{0x10, 0, 0x25, 0x10, 0, 5}},
+		{"SLO zp", []byte{0x07, 0x10}, 0x01, 0, 0, 0x24, 0x81, result{0x03, 0, 0x25, 0x02, 0, 5}},
+		{"RLA zp", []byte{0x27, 0x10}, 0xFF, 0, 0, 0x25, 0x81, result{0x03, 0, 0x25, 0x03, 0, 5}},
+		{"SRE zp", []byte{0x47, 0x10}, 0x80, 0, 0, 0x24, 0x03, result{0x81, 0, 0xA5, 0x01, 0, 5}},
+		{"RRA zp", []byte{0x67, 0x10}, 0x10, 0, 0, 0x25, 0x02, result{0x91, 0, 0xA4, 0x81, 0, 5}},
+		{"LAS abs,Y", []byte{0xBB, 0x10, 0x00}, 0, 0, 0, 0x24, 0xF3, result{0xF1, 0xF1, 0xA4, 0xF3, 0xF1, 4}},
+		{"XAA #", []byte{0x8B, 0xFF}, 0x00, 0x0F, 0, 0x24, 0, result{0x0E, 0x0F, 0x24, 0, 0, 2}},
+		{"SBC # ($EB)", []byte{0xEB, 0x01}, 0x10, 0, 0, 0x25, 0, result{0x0F, 0, 0x25, 0, 0, 2}},
+		{"NOP #", []byte{0x80, 0xFF}, 0, 0, 0, 0x24, 0, result{0, 0, 0x24, 0, 0, 2}},
+		{"NOP zp", []byte{0x04, 0x10}, 0, 0, 0, 0x24, 0, result{0, 0, 0x24, 0, 0, 3}},
+		{"NOP abs", []byte{0x0C, 0x10, 0x00}, 0, 0, 0, 0x24, 0, result{0, 0, 0x24, 0, 0, 4}},
+		{"NOP abs,X", []byte{0x1C, 0x10, 0x00}, 0, 0x01, 0, 0x24, 0, result{0, 0x01, 0x24, 0, 0, 4}},
+		{"NOP implied", []byte{0x1A}, 0, 0, 0, 0x24, 0, result{0, 0, 0x24, 0, 0, 2}},
+	}
+	for _, test := range tests {
+		console := newTestConsole(t, testProgram(test.code...))
+		console.RAM[0x10] = test.m
+		cpu := console.CPU
+		cpu.A, cpu.X, cpu.Y = test.a, test.x, test.y
+		cpu.SetFlags(test.p)
+		cycles := cpu.Cycles
+		cpu.Step()
+		got := result{cpu.A, cpu.X, cpu.Flags(), console.RAM[0x10], 0,
+			cpu.Cycles - cycles}
+		if test.want.sp != 0 {
+			got.sp = cpu.SP
+		}
+		if got != test.want {
+			t.Errorf("%s: got A:%02X X:%02X P:%02X M:%02X SP:%02X %d cycles, "+
+				"want A:%02X X:%02X P:%02X M:%02X SP:%02X %d cycles", test.name,
+				got.a, got.x, got.p, got.m, got.sp, got.cycles,
+				test.want.a, test.want.x, test.want.p, test.want.m,
+				test.want.sp, test.want.cycles)
+		}
+		if pc := 0x8000 + uint16(len(test.code)); cpu.PC != pc {
+			t.Errorf("%s: PC = $%04X, want $%04X", test.name, cpu.PC, pc)
+		}
+	}
+}
+
+// busAccess is a CPU bus cycle seen by busLogger
+type busAccess struct {
+	address uint16
+	value   byte
+	write   bool
+}
+
+func (a busAccess) String() string {
+	if a.write {
+		return fmt.Sprintf("W $%04X $%02X", a.address, a.value)
+	}
+	return fmt.Sprintf("R $%04X $%02X", a.address, a.value)
+}
+
+// busLogger records every access the CPU makes along with how far the CPU,
+// PPU and APU clocks had run when it happened
+type busLogger struct {
+	Memory
+	console  *Console
+	accesses []busAccess
+	clocks   [][3]uint64 // CPU cycle, PPU dot and APU cycle of each access
+}
+
+func (l *busLogger) Read(address uint16) byte {
+	value := l.Memory.Read(address)
+	l.log(busAccess{address, value, false})
+	return value
+}
+
+func (l *busLogger) Write(address uint16, value byte) {
+	l.Memory.Write(address, value)
+	l.log(busAccess{address, value, true})
+}
+
+func (l *busLogger) log(access busAccess) {
+	ppu := l.console.PPU
+	dot := ppu.Frame*341*262 + uint64(ppu.ScanLine*341+ppu.Cycle)
+	l.accesses = append(l.accesses, access)
+	l.clocks = append(l.clocks,
+		[3]uint64{l.console.CPU.Cycles, dot, l.console.APU.cycle})
+}
+
+// TestBusCycles checks the exact bus access made on each cycle of indexed
+// instructions and JSR, including the dummy reads from the address before
+// the page carry, the double write of read-modify-write instructions, JSR
+// fetching its high byte last, and that the PPU and APU run between
+// accesses
+func TestBusCycles(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		x, y, a  byte
+		ram      map[uint16]byte
+		accesses []busAccess
+	}{
+		{
+			"INC abs,X", []byte{0xFE, 0x00, 0x03}, 0x05, 0, 0,
+			map[uint16]byte{0x0305: 0x41},
+			[]busAccess{
+				{0x8000, 0xFE, false},
+				{0x8001, 0x00, false},
+				{0x8002, 0x03, false},
+				{0x0305, 0x41, false}, // dummy read, no carry needed
+				{0x0305, 0x41, false},
+				{0x0305, 0x41, true}, // unmodified value written back
+				{0x0305, 0x42, true},
+			},
+		},
+		{
+			"INC abs,X page cross", []byte{0xFE, 0xFE, 0x02}, 0x05, 0, 0,
+			map[uint16]byte{0x0203: 0x99, 0x0303: 0x41},
+			[]busAccess{
+				{0x8000, 0xFE, false},
+				{0x8001, 0xFE, false},
+				{0x8002, 0x02, false},
+				{0x0203, 0x99, false}, // dummy read before the carry
+				{0x0303, 0x41, false},
+				{0x0303, 0x41, true},
+				{0x0303, 0x42, true},
+			},
+		},
+		{
+			"STA (zp),Y", []byte{0x91, 0x10}, 0, 0x05, 0x77,
+			map[uint16]byte{0x0010: 0x00, 0x0011: 0x03},
+			[]busAccess{
+				{0x8000, 0x91, false},
+				{0x8001, 0x10, false},
+				{0x0010, 0x00, false},
+				{0x0011, 0x03, false},
+				{0x0305, 0x00, false}, // dummy read, no carry needed
+				{0x0305, 0x77, true},
+			},
+		},
+		{
+			"STA (zp),Y page cross", []byte{0x91, 0x10}, 0, 0x05, 0x77,
+			map[uint16]byte{0x0010: 0xFE, 0x0011: 0x02, 0x0203: 0x99},
+			[]busAccess{
+				{0x8000, 0x91, false},
+				{0x8001, 0x10, false},
+				{0x0010, 0xFE, false},
+				{0x0011, 0x02, false},
+				{0x0203, 0x99, false}, // dummy read before the carry
+				{0x0303, 0x77, true},
+			},
+		},
+		{
+			"JSR", []byte{0x20, 0x23, 0x81}, 0, 0, 0,
+			map[uint16]byte{0x01FD: 0x55},
+			[]busAccess{
+				{0x8000, 0x20, false},
+				{0x8001, 0x23, false},
+				{0x01FD, 0x55, false}, // dummy stack read
+				{0x01FD, 0x80, true},
+				{0x01FC, 0x02, true},
+				{0x8002, 0x81, false}, // high byte fetched last
+			},
+		},
+	}
+	for _, test := range tests {
+		console := newTestConsole(t, testProgram(test.code...))
+		for address, value := range test.ram {
+			console.RAM[address] = value
+		}
+		cpu := console.CPU
+		cpu.X, cpu.Y, cpu.A = test.x, test.y, test.a
+		logger := &busLogger{Memory: cpu.Memory, console: console}
+		cpu.Memory = logger
+		start := [3]uint64{cpu.Cycles, 0, console.APU.cycle}
+		ppu := console.PPU
+		start[1] = ppu.Frame*341*262 + uint64(ppu.ScanLine*341+ppu.Cycle)
+
+		cycles := cpu.Step()
+		if cycles != len(test.accesses) {
+			t.Errorf("%s: took %d cycles, want %d",
+				test.name, cycles, len(test.accesses))
+		}
+		if len(logger.accesses) != len(test.accesses) {
+			t.Errorf("%s: got accesses %v, want %v",
+				test.name, logger.accesses, test.accesses)
+			continue
+		}
+		for i, want := range test.accesses {
+			if got := logger.accesses[i]; got != want {
+				t.Errorf("%s: cycle %d is %s, want %s", test.name, i+1, got, want)
+			}
+			// each access happens on its own cycle, after the PPU has run
+			// three dots and the APU one cycle more than the previous one
+			n := uint64(i + 1)
+			clocks := logger.clocks[i]
+			if clocks[0] != start[0]+n || clocks[1] != start[1]+3*n ||
+				clocks[2] != start[2]+n {
+				t.Errorf("%s: cycle %d ran at CPU %d PPU %d APU %d, want %d %d %d",
+					test.name, i+1, clocks[0], clocks[1], clocks[2],
+					start[0]+n, start[1]+3*n, start[2]+n)
+			}
+		}
+	}
+}
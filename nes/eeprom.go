@@ -0,0 +1,215 @@
+package nes
+
+import "encoding/gob"
+
+// serial EEPROM states
+const (
+	eepromIdle = iota
+	eepromChipAddress
+	eepromAddress
+	eepromRead
+	eepromWrite
+	eepromSendAck
+	eepromWaitAck
+)
+
+// serialEEPROM is a 24C01 or 24C02 I2C EEPROM, driven one bit at a time by
+// the game through the SCL and SDA lines. The 24C02 takes a device select
+// byte and sends bits most significant first. The 24C01 has no device
+// select, takes a 7-bit address straight after the start condition and
+// sends bits least significant first. The contents are passed in by the
+// mapper, which keeps them in the cartridge save RAM.
+// http://wiki.nesdev.com/w/index.php/Bandai_FCG_board#Serial_EEPROM
+type serialEEPROM struct {
+	x24C01      bool
+	mode        int
+	nextMode    int
+	chipAddress byte
+	address     byte
+	data        byte
+	counter     uint
+	output      byte // SDA as driven by the EEPROM, 1 when released
+	scl         byte
+	sda         byte
+}
+
+func newSerialEEPROM(x24C01 bool) *serialEEPROM {
+	return &serialEEPROM{x24C01: x24C01, output: 1}
+}
+
+func (e *serialEEPROM) Save(encoder *gob.Encoder) error {
+	encoder.Encode(e.mode)
+	encoder.Encode(e.nextMode)
+	encoder.Encode(e.chipAddress)
+	encoder.Encode(e.address)
+	encoder.Encode(e.data)
+	encoder.Encode(e.counter)
+	encoder.Encode(e.output)
+	encoder.Encode(e.scl)
+	encoder.Encode(e.sda)
+	return nil
+}
+
+func (e *serialEEPROM) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&e.mode)
+	decoder.Decode(&e.nextMode)
+	decoder.Decode(&e.chipAddress)
+	decoder.Decode(&e.address)
+	decoder.Decode(&e.data)
+	decoder.Decode(&e.counter)
+	decoder.Decode(&e.output)
+	decoder.Decode(&e.scl)
+	decoder.Decode(&e.sda)
+	return nil
+}
+
+// read returns the level the EEPROM drives SDA to
+func (e *serialEEPROM) read() byte {
+	return e.output
+}
+
+// write sets the SCL and SDA lines. A falling SDA while SCL is high is a
+// start condition and a rising one a stop condition; otherwise bits are
+// taken on the rising edge of SCL and the next state is entered on the
+// falling edge.
+func (e *serialEEPROM) write(memory []byte, scl, sda byte) {
+	switch {
+	case e.scl == 1 && scl == 1 && sda < e.sda:
+		e.start()
+	case e.scl == 1 && scl == 1 && sda > e.sda:
+		e.mode = eepromIdle
+		e.output = 1
+	case e.scl == 0 && scl == 1:
+		e.risingEdge(memory, sda)
+	case e.scl == 1 && scl == 0:
+		e.fallingEdge(memory)
+	}
+	e.scl = scl
+	e.sda = sda
+}
+
+func (e *serialEEPROM) start() {
+	if e.x24C01 {
+		e.mode = eepromAddress
+		e.address = 0
+	} else {
+		e.mode = eepromChipAddress
+	}
+	e.counter = 0
+	e.output = 1
+}
+
+func (e *serialEEPROM) risingEdge(memory []byte, sda byte) {
+	switch e.mode {
+	case eepromChipAddress:
+		e.writeBit(&e.chipAddress, sda)
+	case eepromAddress:
+		if !e.x24C01 {
+			e.writeBit(&e.address, sda)
+		} else if e.counter < 7 {
+			e.writeBit(&e.address, sda)
+		} else if e.counter == 7 {
+			// the eighth bit selects reading or writing
+			e.counter = 8
+			if sda != 0 {
+				e.nextMode = eepromRead
+				e.data = memory[e.index(memory)]
+			} else {
+				e.nextMode = eepromWrite
+			}
+		}
+	case eepromRead:
+		if e.counter < 8 {
+			e.output = e.data >> e.bit() & 1
+			e.counter++
+		}
+	case eepromWrite:
+		e.writeBit(&e.data, sda)
+	case eepromSendAck:
+		e.output = 0
+	case eepromWaitAck:
+		// the host acknowledges a byte to read the next one
+		if sda == 0 {
+			e.nextMode = eepromRead
+			e.data = memory[e.index(memory)]
+		} else {
+			e.nextMode = eepromIdle
+		}
+	}
+}
+
+func (e *serialEEPROM) fallingEdge(memory []byte) {
+	switch e.mode {
+	case eepromChipAddress:
+		if e.counter < 8 {
+			return
+		}
+		if e.chipAddress&0xF0 != 0xA0 {
+			// another device
+			e.mode = eepromIdle
+			e.output = 1
+			return
+		}
+		e.ack(eepromAddress)
+		if e.chipAddress&1 != 0 {
+			e.nextMode = eepromRead
+			e.data = memory[e.index(memory)]
+		}
+	case eepromAddress:
+		if e.counter < 8 {
+			return
+		}
+		if e.x24C01 {
+			// the read or write was already chosen with the address
+			e.ack(e.nextMode)
+		} else {
+			e.ack(eepromWrite)
+		}
+	case eepromRead:
+		if e.counter == 8 {
+			e.mode = eepromWaitAck
+			e.address++
+		}
+	case eepromWrite:
+		if e.counter < 8 {
+			return
+		}
+		memory[e.index(memory)] = e.data
+		e.address++
+		e.ack(eepromWrite)
+	case eepromSendAck, eepromWaitAck:
+		e.mode = e.nextMode
+		e.counter = 0
+		e.output = 1
+	}
+}
+
+// ack pulls SDA low for the next clock to acknowledge a byte, then moves
+// on to the next state
+func (e *serialEEPROM) ack(next int) {
+	e.mode = eepromSendAck
+	e.nextMode = next
+	e.counter = 0
+	e.output = 1
+}
+
+func (e *serialEEPROM) writeBit(dest *byte, value byte) {
+	if e.counter < 8 {
+		bit := e.bit()
+		*dest = *dest&^(1<<bit) | (value&1)<<bit
+		e.counter++
+	}
+}
+
+// bit is the position of the next bit sent or received
+func (e *serialEEPROM) bit() uint {
+	if e.x24C01 {
+		return e.counter
+	}
+	return 7 - e.counter
+}
+
+// index wraps the address to the size of the EEPROM
+func (e *serialEEPROM) index(memory []byte) int {
+	return int(e.address) % len(memory)
+}
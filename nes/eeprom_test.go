@@ -0,0 +1,171 @@
+package nes
+
+import "testing"
+
+// i2cHost drives a serialEEPROM the way a game does, one line change per
+// write, and checks what the EEPROM puts on SDA
+type i2cHost struct {
+	t        *testing.T
+	eeprom   *serialEEPROM
+	memory   []byte
+	msbFirst bool
+}
+
+func newI2CHost(t *testing.T, x24C01 bool, size int) *i2cHost {
+	return &i2cHost{
+		t: t, eeprom: newSerialEEPROM(x24C01), memory: make([]byte, size),
+		msbFirst: !x24C01}
+}
+
+func (h *i2cHost) set(scl, sda byte) {
+	h.eeprom.write(h.memory, scl, sda)
+}
+
+// start pulls SDA low while SCL is high, which also works as a repeated
+// start in the middle of a transfer
+func (h *i2cHost) start() {
+	h.set(0, 1)
+	h.set(1, 1)
+	h.set(1, 0)
+	h.set(0, 0)
+}
+
+// stop lets SDA rise while SCL is high
+func (h *i2cHost) stop() {
+	h.set(0, 0)
+	h.set(1, 0)
+	h.set(1, 1)
+}
+
+// clock sends one bit and returns SDA as seen while SCL is high
+func (h *i2cHost) clock(bit byte) byte {
+	h.set(0, bit)
+	h.set(1, bit)
+	sda := h.eeprom.read()
+	h.set(0, bit)
+	return sda
+}
+
+func (h *i2cHost) bit(i uint) uint {
+	if h.msbFirst {
+		return 7 - i
+	}
+	return i
+}
+
+// send clocks out a byte, checking the EEPROM leaves SDA alone meanwhile
+func (h *i2cHost) send(value byte) {
+	for i := uint(0); i < 8; i++ {
+		if sda := h.clock(value >> h.bit(i) & 1); sda != 1 {
+			h.t.Fatalf("EEPROM drove SDA low while receiving bit %d of $%02X", i, value)
+		}
+	}
+}
+
+// expectAck clocks the acknowledge bit and checks the EEPROM pulled SDA low
+func (h *i2cHost) expectAck(what string) {
+	if sda := h.clock(1); sda != 0 {
+		h.t.Fatalf("no acknowledge after %s", what)
+	}
+}
+
+// receive reads a byte from the EEPROM, then acknowledges it to continue
+// reading or not to finish
+func (h *i2cHost) receive(ack bool) byte {
+	var value byte
+	for i := uint(0); i < 8; i++ {
+		value |= h.clock(1) << h.bit(i)
+	}
+	if ack {
+		h.clock(0)
+	} else {
+		h.clock(1)
+	}
+	return value
+}
+
+func TestEEPROM24C02(t *testing.T) {
+	h := newI2CHost(t, false, 256)
+	h.memory[0x12] = 0x56
+
+	// write $12 $34 to $10 and $11
+	h.start()
+	h.send(0xA0)
+	h.expectAck("device select")
+	h.send(0x10)
+	h.expectAck("word address")
+	h.send(0x12)
+	h.expectAck("first data byte")
+	h.send(0x34)
+	h.expectAck("second data byte")
+	h.stop()
+	if h.memory[0x10] != 0x12 || h.memory[0x11] != 0x34 {
+		t.Fatalf("memory after write = $%02X $%02X, want $12 $34",
+			h.memory[0x10], h.memory[0x11])
+	}
+	if h.eeprom.read() != 1 {
+		t.Fatal("SDA not released after stop")
+	}
+
+	// random read from $11: a dummy write sets the address, then a
+	// repeated start turns the transfer around
+	h.start()
+	h.send(0xA0)
+	h.expectAck("device select")
+	h.send(0x11)
+	h.expectAck("word address")
+	h.start()
+	h.send(0xA1)
+	h.expectAck("device select for reading")
+	if value := h.receive(true); value != 0x34 {
+		t.Errorf("first byte read = $%02X, want $34", value)
+	}
+	if value := h.receive(false); value != 0x56 {
+		t.Errorf("second byte read = $%02X, want $56", value)
+	}
+	h.stop()
+	if h.eeprom.read() != 1 {
+		t.Fatal("SDA not released after stop")
+	}
+	if h.memory[0x11] != 0x34 || h.memory[0x12] != 0x56 {
+		t.Error("random read changed memory")
+	}
+
+	// another device's address is ignored
+	h.start()
+	h.send(0x50)
+	if sda := h.clock(1); sda != 1 {
+		t.Error("EEPROM acknowledged another device")
+	}
+	h.stop()
+}
+
+func TestEEPROM24C01(t *testing.T) {
+	h := newI2CHost(t, true, 128)
+	h.memory[0x05] = 0xA5
+	h.memory[0x06] = 0x3C
+
+	// the 24C01 takes a 7-bit address and then the read bit straight after
+	// the start condition, least significant bit first
+	h.start()
+	h.send(0x05 | 0x80)
+	h.expectAck("address")
+	if value := h.receive(true); value != 0xA5 {
+		t.Errorf("first byte read = $%02X, want $A5", value)
+	}
+	if value := h.receive(false); value != 0x3C {
+		t.Errorf("second byte read = $%02X, want $3C", value)
+	}
+	h.stop()
+
+	// write $81 to $7F
+	h.start()
+	h.send(0x7F)
+	h.expectAck("address")
+	h.send(0x81)
+	h.expectAck("data byte")
+	h.stop()
+	if h.memory[0x7F] != 0x81 {
+		t.Errorf("memory after write = $%02X, want $81", h.memory[0x7F])
+	}
+}
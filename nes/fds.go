@@ -0,0 +1,177 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// FDSBIOSPath is the location of the Famicom Disk System BIOS (disksys.rom)
+// used to boot .fds images. The BIOS is not distributed with the emulator.
+var FDSBIOSPath string
+
+const (
+	fdsSideSize   = 65500 // bytes per disk side in a .fds image
+	fdsLeadIn     = 28300 / 8
+	fdsBlockGap   = 976 / 8
+	fdsBIOSSize   = 8192
+	fdsRAMSize    = 32768
+	fdsHeaderSize = 16
+)
+
+var fdsHeaderMagic = []byte("FDS\x1a")
+
+var fdsDiskMagic = []byte("\x01*NINTENDO-HVC*")
+
+// isFDSImage reports whether data starts like a .fds image, either with the
+// fwNES header or directly with the first disk side
+func isFDSImage(data []byte) bool {
+	return bytes.HasPrefix(data, fdsHeaderMagic) ||
+		bytes.HasPrefix(data, fdsDiskMagic)
+}
+
+// LoadFDSFile reads a Famicom Disk System image (.fds) and the BIOS at
+// FDSBIOSPath, and returns a Cartridge holding the disk sides
+// http://wiki.nesdev.com/w/index.php/FDS_file_format
+func LoadFDSFile(path string) (*Cartridge, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, fdsHeaderMagic) {
+		data = data[fdsHeaderSize:]
+	}
+	if !bytes.HasPrefix(data, fdsDiskMagic) {
+		return nil, errors.New("invalid .fds file")
+	}
+	if FDSBIOSPath == "" {
+		return nil, errors.New("no FDS BIOS configured")
+	}
+	bios, err := ioutil.ReadFile(FDSBIOSPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(bios) != fdsBIOSSize {
+		return nil, errors.New("invalid FDS BIOS: " + FDSBIOSPath)
+	}
+
+	// the drive streams the disk with its gaps and checksums, which the
+	// image leaves out, so they are added back to each side
+	var disk [][]byte
+	for len(data) > 0 {
+		side := make([]byte, fdsSideSize)
+		copy(side, data)
+		disk = append(disk, addDiskGaps(side))
+		if len(data) < fdsSideSize {
+			break
+		}
+		data = data[fdsSideSize:]
+	}
+
+	cartridge := NewCartridge(bios, make([]byte, 8192), 20, MirrorHorizontal, 0)
+	cartridge.SRAM = make([]byte, fdsRAMSize)
+	cartridge.PRGRAMSize = fdsRAMSize
+	cartridge.CHRRAMSize = 8192
+	cartridge.Disk = disk
+	cartridge.originalDisk = make([][]byte, len(disk))
+	for i, side := range disk {
+		cartridge.originalDisk[i] = append([]byte(nil), side...)
+	}
+	return cartridge, nil
+}
+
+// addDiskGaps converts a disk side from the .fds layout to the layout the
+// drive sees: a lead-in gap, then each block preceded by a start mark and
+// followed by a CRC and another gap. Unused space is kept at the end.
+func addDiskGaps(side []byte) []byte {
+	result := make([]byte, fdsLeadIn, len(side)*9/8)
+	i := 0
+	for i < len(side) {
+		var length int
+		switch side[i] {
+		case 1: // disk info
+			length = 56
+		case 2: // file amount
+			length = 2
+		case 3: // file header
+			length = 16
+		case 4: // file data, sized by the preceding file header
+			if i < 3 {
+				length = -1
+				break
+			}
+			length = 1 + (int(side[i-3]) | int(side[i-2])<<8)
+		default:
+			length = -1
+		}
+		if length < 0 || i+length > len(side) {
+			break
+		}
+		result = append(result, 0x80)
+		result = append(result, side[i:i+length]...)
+		// the drive does not check CRCs, so any value will do
+		result = append(result, 0x4D, 0x62)
+		result = append(result, make([]byte, fdsBlockGap)...)
+		i += length
+	}
+	return append(result, make([]byte, len(side)-i)...)
+}
+
+// DiskDiff returns the changes written to the disk since it was loaded, in
+// a form that ApplyDiskDiff restores. It is empty for unchanged disks.
+func (cartridge *Cartridge) DiskDiff() []byte {
+	var buf bytes.Buffer
+	for i, side := range cartridge.Disk {
+		original := cartridge.originalDisk[i]
+		for j := 0; j < len(side); {
+			if side[j] == original[j] {
+				j++
+				continue
+			}
+			start := j
+			for j < len(side) && side[j] != original[j] {
+				j++
+			}
+			binary.Write(&buf, binary.LittleEndian, uint16(i))
+			binary.Write(&buf, binary.LittleEndian, uint32(start))
+			binary.Write(&buf, binary.LittleEndian, uint32(j-start))
+			buf.Write(side[start:j])
+		}
+	}
+	return buf.Bytes()
+}
+
+// ApplyDiskDiff replaces the disk contents with the original image plus the
+// changes recorded by DiskDiff
+func (cartridge *Cartridge) ApplyDiskDiff(diff []byte) error {
+	disk := make([][]byte, len(cartridge.originalDisk))
+	for i, side := range cartridge.originalDisk {
+		disk[i] = append([]byte(nil), side...)
+	}
+	r := bytes.NewReader(diff)
+	for r.Len() > 0 {
+		var record struct {
+			Side   uint16
+			Offset uint32
+			Length uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &record); err != nil {
+			return err
+		}
+		side := int(record.Side)
+		end := int64(record.Offset) + int64(record.Length)
+		if side >= len(disk) || end > int64(len(disk[side])) {
+			return errors.New("disk diff does not match disk image")
+		}
+		chunk := disk[side][record.Offset:end]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return err
+		}
+	}
+	for i := range disk {
+		copy(cartridge.Disk[i], disk[i])
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package nes
+
+import "encoding/gob"
+
+// fdsModSteps is how far each 3-bit modulation table entry moves the
+// modulation counter; entry 4 resets it instead
+var fdsModSteps = [8]int{0, 1, 2, 4, 0, -4, -2, -1}
+
+// fdsMasterVolume is the output scale selected by $4089, in 30ths
+var fdsMasterVolume = [4]int{30, 20, 15, 12}
+
+// fdsAudio is the 2C33 sound channel of the Famicom Disk System: a 64-step
+// wavetable with a volume envelope and a frequency modulation unit driven
+// by its own 64-entry table
+// http://wiki.nesdev.com/w/index.php/FDS_audio
+type fdsAudio struct {
+	wave         [64]byte
+	waveWrite    bool
+	waveHalt     bool
+	frequency    int
+	accumulator  int
+	masterVolume byte
+	envelopeHalt bool
+	envelopeRate byte // $408A
+	volume       fdsEnvelope
+	modulation   fdsEnvelope
+
+	modTable     [64]byte
+	modPosition  int
+	modCounter   int // 7-bit signed
+	modFrequency int
+	modAccum     int
+	modHalt      bool
+}
+
+// fdsEnvelope is the volume or modulation gain envelope
+type fdsEnvelope struct {
+	direct   bool
+	increase bool
+	speed    byte
+	gain     byte
+	timer    int
+}
+
+func (e *fdsEnvelope) Save(encoder *gob.Encoder) error {
+	encoder.Encode(e.direct)
+	encoder.Encode(e.increase)
+	encoder.Encode(e.speed)
+	encoder.Encode(e.gain)
+	encoder.Encode(e.timer)
+	return nil
+}
+
+func (e *fdsEnvelope) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&e.direct)
+	decoder.Decode(&e.increase)
+	decoder.Decode(&e.speed)
+	decoder.Decode(&e.gain)
+	decoder.Decode(&e.timer)
+	return nil
+}
+
+func (e *fdsEnvelope) write(value byte) {
+	e.direct = value&0x80 != 0
+	e.increase = value&0x40 != 0
+	e.speed = value & 0x3F
+	e.timer = 0
+	if e.direct {
+		e.gain = e.speed
+	}
+}
+
+func (e *fdsEnvelope) step(rate byte) {
+	if e.direct {
+		return
+	}
+	e.timer++
+	if e.timer < 8*int(e.speed+1)*int(rate) {
+		return
+	}
+	e.timer = 0
+	if e.increase && e.gain < 32 {
+		e.gain++
+	} else if !e.increase && e.gain > 0 {
+		e.gain--
+	}
+}
+
+func (f *fdsAudio) Save(encoder *gob.Encoder) error {
+	encoder.Encode(f.wave)
+	encoder.Encode(f.waveWrite)
+	encoder.Encode(f.waveHalt)
+	encoder.Encode(f.frequency)
+	encoder.Encode(f.accumulator)
+	encoder.Encode(f.masterVolume)
+	encoder.Encode(f.envelopeHalt)
+	encoder.Encode(f.envelopeRate)
+	f.volume.Save(encoder)
+	f.modulation.Save(encoder)
+	encoder.Encode(f.modTable)
+	encoder.Encode(f.modPosition)
+	encoder.Encode(f.modCounter)
+	encoder.Encode(f.modFrequency)
+	encoder.Encode(f.modAccum)
+	encoder.Encode(f.modHalt)
+	return nil
+}
+
+func (f *fdsAudio) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&f.wave)
+	decoder.Decode(&f.waveWrite)
+	decoder.Decode(&f.waveHalt)
+	decoder.Decode(&f.frequency)
+	decoder.Decode(&f.accumulator)
+	decoder.Decode(&f.masterVolume)
+	decoder.Decode(&f.envelopeHalt)
+	decoder.Decode(&f.envelopeRate)
+	f.volume.Load(decoder)
+	f.modulation.Load(decoder)
+	decoder.Decode(&f.modTable)
+	decoder.Decode(&f.modPosition)
+	decoder.Decode(&f.modCounter)
+	decoder.Decode(&f.modFrequency)
+	decoder.Decode(&f.modAccum)
+	decoder.Decode(&f.modHalt)
+	return nil
+}
+
+// reset puts the channel in its power-on state, which is silent
+func (f *fdsAudio) reset() {
+	*f = fdsAudio{}
+	f.waveHalt = true
+	f.modHalt = true
+	f.envelopeRate = 0xE8
+}
+
+func (f *fdsAudio) readRegister(address uint16) byte {
+	switch {
+	case address < 0x4080:
+		return f.wave[address-0x4040] | 0x40
+	case address == 0x4090:
+		return f.volume.gain | 0x40
+	case address == 0x4092:
+		return f.modulation.gain | 0x40
+	}
+	return 0
+}
+
+func (f *fdsAudio) writeRegister(address uint16, value byte) {
+	switch {
+	case address < 0x4080:
+		if f.waveWrite {
+			f.wave[address-0x4040] = value & 0x3F
+		}
+	case address == 0x4080:
+		f.volume.write(value)
+	case address == 0x4082:
+		f.frequency = f.frequency&0xF00 | int(value)
+	case address == 0x4083:
+		f.frequency = f.frequency&0x0FF | int(value&15)<<8
+		f.waveHalt = value&0x80 != 0
+		f.envelopeHalt = value&0x40 != 0
+		if f.waveHalt {
+			f.accumulator = 0
+		}
+	case address == 0x4084:
+		f.modulation.write(value)
+	case address == 0x4085:
+		f.modCounter = signExtend7(int(value))
+	case address == 0x4086:
+		f.modFrequency = f.modFrequency&0xF00 | int(value)
+	case address == 0x4087:
+		f.modFrequency = f.modFrequency&0x0FF | int(value&15)<<8
+		f.modHalt = value&0x80 != 0
+		if f.modHalt {
+			f.modAccum = 0
+		}
+	case address == 0x4088:
+		// the table can only be written while modulation is halted, and
+		// each write fills two entries
+		if f.modHalt {
+			f.modTable[f.modPosition] = value & 7
+			f.modTable[(f.modPosition+1)&63] = value & 7
+			f.modPosition = (f.modPosition + 2) & 63
+		}
+	case address == 0x4089:
+		f.waveWrite = value&0x80 != 0
+		f.masterVolume = value & 3
+	case address == 0x408A:
+		f.envelopeRate = value
+	}
+}
+
+// step is called once per CPU cycle
+func (f *fdsAudio) step() {
+	if !f.envelopeHalt && !f.waveHalt && f.envelopeRate != 0 {
+		f.volume.step(f.envelopeRate)
+		f.modulation.step(f.envelopeRate)
+	}
+	if !f.modHalt && f.modFrequency != 0 {
+		f.modAccum += f.modFrequency
+		if f.modAccum >= 0x10000 {
+			f.modAccum &= 0xFFFF
+			f.stepModulation()
+		}
+	}
+	if !f.waveHalt && !f.waveWrite {
+		f.accumulator = (f.accumulator + f.pitch()) & 0x3FFFFF
+	}
+}
+
+func (f *fdsAudio) stepModulation() {
+	value := f.modTable[f.modPosition]
+	f.modPosition = (f.modPosition + 1) & 63
+	if value == 4 {
+		f.modCounter = 0
+	} else {
+		f.modCounter += fdsModSteps[value]
+	}
+	f.modCounter = signExtend7(f.modCounter)
+}
+
+// signExtend7 wraps a value to 7 bits and sign-extends it, so that the
+// modulation counter runs from -64 to 63
+func signExtend7(value int) int {
+	return int(int8(value<<1)) >> 1
+}
+
+// pitch returns the wave frequency after modulation, following the
+// hardware's integer arithmetic and rounding
+func (f *fdsAudio) pitch() int {
+	if f.modHalt {
+		return f.frequency
+	}
+	temp := f.modCounter * int(f.modulation.gain)
+	remainder := temp & 0x0F
+	temp >>= 4
+	if remainder > 0 && temp&0x80 == 0 {
+		if f.modCounter < 0 {
+			temp--
+		} else {
+			temp += 2
+		}
+	}
+	if temp >= 192 {
+		temp -= 256
+	} else if temp < -64 {
+		temp += 256
+	}
+	temp *= f.frequency
+	remainder = temp & 0x3F
+	temp >>= 6
+	if remainder >= 32 {
+		temp++
+	}
+	pitch := f.frequency + temp
+	if pitch < 0 {
+		return 0
+	}
+	return pitch
+}
+
+// output is scaled so that the channel at full volume is about 2.4 times
+// as loud as a full volume APU pulse, as on hardware
+func (f *fdsAudio) output() float32 {
+	gain := int(f.volume.gain)
+	if gain > 32 {
+		gain = 32
+	}
+	sample := int(f.wave[f.accumulator>>16&63]) * gain
+	sample = sample * fdsMasterVolume[f.masterVolume] / 30
+	return float32(sample) / (63 * 32) * 2.4 * pulseTable[15]
+}
@@ -0,0 +1,84 @@
+package nes
+
+import "hash/crc32"
+
+// GameInfo holds the board details of a game that an iNES header can't
+// describe, in the same form as a NES 2.0 header
+type GameInfo struct {
+	Mapper       uint16
+	Submapper    byte
+	PRGRAMSize   int // volatile PRG-RAM size in bytes
+	PRGNVRAMSize int // battery-backed PRG-RAM size in bytes
+	CHRRAMSize   int // volatile CHR-RAM size in bytes
+	CHRNVRAMSize int // battery-backed CHR-RAM size in bytes
+}
+
+// gameDatabase maps the CRC-32 of a game's PRG-ROM followed by its CHR-ROM
+// to its board details
+var gameDatabase = map[uint32]GameInfo{}
+
+func init() {
+	// MMC1 boards with more than 8KB of PRG-RAM
+	sorom := GameInfo{Mapper: 1, PRGRAMSize: 0x2000, PRGNVRAMSize: 0x2000}
+	sxrom := GameInfo{Mapper: 1, PRGNVRAMSize: 0x8000}
+	RegisterGame(0xC6182024, sorom) // Romance of the Three Kingdoms (U)
+	RegisterGame(0xABBF7217, sorom) // Sangokushi (J) (PRG0)
+	RegisterGame(0xCCF35C02, sorom) // Sangokushi (J) (PRG1)
+	RegisterGame(0x2225C20F, sorom) // Genghis Khan (U)
+	RegisterGame(0xFB69743A, sorom) // Aoki Ookami to Shiroki Mejika (J)
+	RegisterGame(0x4642DDA6, sorom) // Nobunaga's Ambition (U)
+	RegisterGame(0x3F7AD415, sorom) // Nobunaga no Yabou (J) (PRG0)
+	RegisterGame(0x2B11E0B0, sorom) // Nobunaga no Yabou (J) (PRG1)
+	RegisterGame(0xB8747ABF, sxrom) // Best Play Pro Yakyuu Special (J)
+	RegisterGame(0xC3DE7C69, sxrom) // Best Play Pro Yakyuu Special (J) (alt)
+	RegisterGame(0xC9556B36, sxrom) // Final Fantasy I & II (J)
+
+	// MMC5 boards with more than 8KB of PRG-RAM
+	etrom := GameInfo{Mapper: 5, PRGRAMSize: 0x2000, PRGNVRAMSize: 0x2000}
+	ewrom := GameInfo{Mapper: 5, PRGNVRAMSize: 0x8000}
+	RegisterGame(0x15FE6D0F, etrom) // Bandit Kings of Ancient China (U)
+	RegisterGame(0xFE3488D1, etrom) // Daikoukai Jidai (J)
+	RegisterGame(0x6396B988, etrom) // L'Empereur (J)
+	RegisterGame(0x9C18762B, etrom) // L'Empereur (U)
+	RegisterGame(0xEEE9A682, etrom) // Nobunaga no Yabou - Sengoku Gunyuu Den (J) (PRG0)
+	RegisterGame(0xF9B4240F, etrom) // Nobunaga no Yabou - Sengoku Gunyuu Den (J) (PRG1)
+	RegisterGame(0x8CE478DB, etrom) // Nobunaga's Ambition II (U)
+	RegisterGame(0x39F2CE4B, etrom) // Suikoden - Tenmei no Chikai (J)
+	RegisterGame(0xACA15643, etrom) // Uncharted Waters (U)
+	RegisterGame(0x6F4E4312, ewrom) // Aoki Ookami to Shiroki Mejika - Genchou Hishi (J)
+	RegisterGame(0xF540677B, ewrom) // Nobunaga no Yabou - Bushou Fuuun Roku (J)
+
+	// MMC6 games, with 1KB of battery-backed RAM inside the mapper
+	mmc6 := GameInfo{Mapper: 4, Submapper: 1, PRGNVRAMSize: 0x400}
+	RegisterGame(0x889129CB, mmc6) // StarTropics (U)
+	RegisterGame(0xD054FFB0, mmc6) // Zoda's Revenge - StarTropics II (U)
+}
+
+// RegisterGame adds a game to the database consulted for iNES files, keyed
+// by the CRC-32 of its PRG-ROM followed by its CHR-ROM. NES 2.0 headers
+// are trusted over the database. Like RegisterMapper it is meant to be
+// called from init functions.
+func RegisterGame(crc uint32, info GameInfo) {
+	gameDatabase[crc] = info
+}
+
+// lookupGame finds a game in the database by its ROM contents
+func lookupGame(prg, chr []byte) (GameInfo, bool) {
+	crc := crc32.Update(crc32.ChecksumIEEE(prg), crc32.IEEETable, chr)
+	info, ok := gameDatabase[crc]
+	return info, ok
+}
+
+// applyGameInfo replaces the board details read from an iNES header
+func (cartridge *Cartridge) applyGameInfo(info GameInfo) {
+	cartridge.Mapper = info.Mapper
+	cartridge.Submapper = info.Submapper
+	cartridge.PRGRAMSize = info.PRGRAMSize
+	cartridge.PRGNVRAMSize = info.PRGNVRAMSize
+	cartridge.CHRRAMSize = info.CHRRAMSize
+	cartridge.CHRNVRAMSize = info.CHRNVRAMSize
+	if info.PRGNVRAMSize > 0 || info.CHRNVRAMSize > 0 {
+		cartridge.Battery = 1
+	}
+	cartridge.sizesKnown = true
+}
@@ -0,0 +1,118 @@
+package nes
+
+import (
+	"hash/crc32"
+	"testing"
+)
+
+// TestGameDatabase loads an iNES file that claims to be NROM, which the
+// database says is an MMC6 board with 1KB of battery-backed RAM
+func TestGameDatabase(t *testing.T) {
+	prg := testProgram(0xEA)
+	chr := make([]byte, 0x2000)
+	crc := crc32.Update(crc32.ChecksumIEEE(prg), crc32.IEEETable, chr)
+	RegisterGame(crc, GameInfo{Mapper: 4, Submapper: 1, PRGNVRAMSize: 0x400})
+	defer delete(gameDatabase, crc)
+
+	console := newTestConsole(t, prg)
+	cartridge := console.Cartridge
+	if cartridge.Mapper != 4 || cartridge.Submapper != 1 {
+		t.Errorf("mapper %d.%d, want 4.1", cartridge.Mapper, cartridge.Submapper)
+	}
+	if _, ok := console.Mapper.(*Mapper4); !ok {
+		t.Errorf("mapper is %T, want *Mapper4", console.Mapper)
+	}
+	// save RAM is never smaller than 8KB, however little the board has
+	if cartridge.Battery != 1 || len(cartridge.SRAM) != 0x2000 {
+		t.Errorf("battery = %d, SRAM = %d bytes, want 1 and 8KB",
+			cartridge.Battery, len(cartridge.SRAM))
+	}
+}
+
+func TestMMC3RAMProtect(t *testing.T) {
+	console := newTestConsole(t, testProgram(0xEA))
+	m := NewMapper4(console, &Cartridge{
+		PRG: make([]byte, 0x8000), CHR: make([]byte, 0x2000),
+		SRAM: make([]byte, 0x2000), Mapper: 4})
+	m.Write(0xA001, 0xC0)
+	m.Write(0x6000, 0x55)
+	if value := m.Read(0x6000); value != 0 {
+		t.Errorf("write-protected RAM = $%02X, want $00", value)
+	}
+	m.Write(0xA001, 0x80)
+	m.Write(0x6000, 0x55)
+	if value := m.Read(0x6000); value != 0x55 {
+		t.Errorf("writable RAM = $%02X, want $55", value)
+	}
+	m.Write(0xA001, 0x00)
+	console.openBus = 0x60
+	if value := m.Read(0x6000); value != 0x60 {
+		t.Errorf("disabled RAM = $%02X, want open bus $60", value)
+	}
+}
+
+func TestMMC6RAM(t *testing.T) {
+	console := newTestConsole(t, testProgram(0xEA))
+	console.openBus = 0x60
+	cartridge := &Cartridge{
+		PRG: make([]byte, 0x8000), CHR: make([]byte, 0x2000),
+		SRAM: make([]byte, 0x2000), Mapper: 4, Submapper: 1}
+	m := NewMapper4(console, cartridge)
+	read := func(address uint16, want byte, what string) {
+		if value := m.Read(address); value != want {
+			t.Errorf("%s: $%04X = $%02X, want $%02X", what, address, value, want)
+		}
+	}
+
+	// $A001 is ignored until $8000 bit 5 enables the RAM
+	m.Write(0xA001, 0xF0)
+	m.Write(0x7005, 0x55)
+	read(0x7005, 0x60, "RAM disabled")
+	m.Write(0x8000, 0x20)
+	read(0x7005, 0x60, "RAM enabled, halves not")
+
+	// the lower 512 bytes readable and writable, the upper ones neither
+	m.Write(0xA001, 0x30)
+	m.Write(0x7005, 0x55)
+	m.Write(0x7205, 0xAA)
+	if cartridge.SRAM[0x005] != 0x55 || cartridge.SRAM[0x205] != 0 {
+		t.Errorf("SRAM = $%02X $%02X after writes, want $55 $00",
+			cartridge.SRAM[0x005], cartridge.SRAM[0x205])
+	}
+	read(0x7005, 0x55, "lower half")
+	read(0x7C05, 0x55, "mirror of the lower half")
+	read(0x7205, 0x00, "unreadable upper half")
+	read(0x6005, 0x60, "below $7000")
+
+	// read-only
+	m.Write(0xA001, 0x20)
+	m.Write(0x7005, 0x11)
+	read(0x7005, 0x55, "read-only lower half")
+
+	// disabling the RAM clears $A001
+	m.Write(0x8000, 0x00)
+	m.Write(0x8000, 0x20)
+	read(0x7005, 0x60, "re-enabled RAM")
+}
+
+func TestETROMRAMChips(t *testing.T) {
+	console := newTestConsole(t, testProgram(0xEA))
+	cartridge := &Cartridge{
+		PRG: make([]byte, 0x8000), CHR: make([]byte, 0x2000),
+		SRAM: make([]byte, 0x4000), Mapper: 5, sizesKnown: true}
+	m := NewMapper5(console, cartridge).(*Mapper5)
+	m.WriteExpansion(0x5102, 2)
+	m.WriteExpansion(0x5103, 1)
+
+	// bit 2 of the bank number selects the chip
+	m.WriteExpansion(0x5113, 4)
+	m.Write(0x6000, 0x55)
+	if cartridge.SRAM[0x2000] != 0x55 {
+		t.Error("bank 4 is not the second chip")
+	}
+	m.WriteExpansion(0x5113, 1)
+	m.Write(0x6000, 0xAA)
+	if cartridge.SRAM[0x0000] != 0xAA {
+		t.Error("bank 1 is not the first chip")
+	}
+}
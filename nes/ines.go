@@ -10,17 +10,24 @@ import (
 const iNESFileMagic = 0x1a53454e
 
 type iNESFileHeader struct {
-	Magic    uint32  // iNES magic number
-	NumPRG   byte    // number of PRG-ROM banks (16KB each)
-	NumCHR   byte    // number of CHR-ROM banks (8KB each)
-	Control1 byte    // control bits
-	Control2 byte    // control bits
-	NumRAM   byte    // PRG-RAM size (x 8KB)
-	_        [7]byte // unused padding
+	Magic     uint32 // iNES magic number
+	NumPRG    byte   // number of PRG-ROM banks (16KB each)
+	NumCHR    byte   // number of CHR-ROM banks (8KB each)
+	Control1  byte   // control bits
+	Control2  byte   // control bits
+	NumRAM    byte   // PRG-RAM size (x 8KB); NES 2.0: mapper high bits, submapper
+	ROMSizes  byte   // NES 2.0: PRG-ROM and CHR-ROM size high bits
+	PRGRAM    byte   // NES 2.0: PRG-RAM and PRG-NVRAM shift counts
+	CHRRAM    byte   // NES 2.0: CHR-RAM and CHR-NVRAM shift counts
+	Timing    byte   // NES 2.0: CPU/PPU timing
+	System    byte   // NES 2.0: Vs. System type or extended console type
+	MiscROMs  byte   // NES 2.0: number of miscellaneous ROMs
+	Expansion byte   // NES 2.0: default expansion device
 }
 
 // LoadNESFile reads an iNES file (.nes) and returns a Cartridge on success.
 // http://wiki.nesdev.com/w/index.php/INES
+// http://wiki.nesdev.com/w/index.php/NES_2.0
 // http://nesdev.com/NESDoc.pdf (page 28)
 func LoadNESFile(path string) (*Cartridge, error) {
 	// open file
@@ -30,6 +37,11 @@ func LoadNESFile(path string) (*Cartridge, error) {
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
 	// read file header
 	header := iNESFileHeader{}
 	if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
@@ -41,44 +53,148 @@ func LoadNESFile(path string) (*Cartridge, error) {
 		return nil, errors.New("invalid .nes file")
 	}
 
+	// rom sizes
+	trainerSize := 0
+	if header.Control1&4 == 4 {
+		trainerSize = 512
+	}
+	prgSize := int(header.NumPRG) * 16384
+	chrSize := int(header.NumCHR) * 8192
+
+	// a NES 2.0 header is only trusted if the rom sizes it declares fit
+	// in the file, otherwise it is most likely a corrupted iNES header
+	nes20 := false
+	if header.Control2&0x0C == 0x08 {
+		prg := nes20ROMSize(header.NumPRG, header.ROMSizes&0x0F, 16384)
+		chr := nes20ROMSize(header.NumCHR, header.ROMSizes>>4, 8192)
+		if prg >= 0 && chr >= 0 &&
+			int64(16+trainerSize+prg+chr) <= info.Size() {
+			nes20 = true
+			prgSize = prg
+			chrSize = chr
+		}
+	}
+
+	// old dumping tools wrote their names over bytes 7-15 ("DiskDude!"), so
+	// if those bytes hold anything else only byte 6 of the mapper is used
+	dirty := !nes20 && (header.Control2&0x0C != 0 || header.Timing != 0 ||
+		header.System != 0 || header.MiscROMs != 0 || header.Expansion != 0)
+
 	// mapper type
 	mapper1 := header.Control1 >> 4
 	mapper2 := header.Control2 >> 4
-	mapper := mapper1 | mapper2<<4
+	mapper := uint16(mapper1) | uint16(mapper2)<<4
+	if dirty {
+		mapper = uint16(mapper1)
+	}
 
 	// mirroring type
-	mirror1 := header.Control1 & 1
-	mirror2 := (header.Control1 >> 3) & 1
-	mirror := mirror1 | mirror2<<1
+	mirror := header.Control1 & 1
+	fourScreen := header.Control1&8 == 8
+	if fourScreen {
+		mirror = MirrorFour
+	}
 
 	// battery-backed RAM
 	battery := (header.Control1 >> 1) & 1
 
-	// read trainer if present (unused)
-	if header.Control1&4 == 4 {
-		trainer := make([]byte, 512)
+	// read trainer if present
+	var trainer []byte
+	if trainerSize > 0 {
+		trainer = make([]byte, trainerSize)
 		if _, err := io.ReadFull(file, trainer); err != nil {
 			return nil, err
 		}
 	}
 
 	// read prg-rom bank(s)
-	prg := make([]byte, int(header.NumPRG)*16384)
+	prg := make([]byte, prgSize)
 	if _, err := io.ReadFull(file, prg); err != nil {
 		return nil, err
 	}
 
 	// read chr-rom bank(s)
-	chr := make([]byte, int(header.NumCHR)*8192)
+	chr := make([]byte, chrSize)
 	if _, err := io.ReadFull(file, chr); err != nil {
 		return nil, err
 	}
 
-	// provide chr-rom/ram if not in file
-	if header.NumCHR == 0 {
-		chr = make([]byte, 8192)
+	cartridge := NewCartridge(prg, chr, mapper, mirror, battery)
+	cartridge.Trainer = trainer
+
+	// four-screen boards carry the two extra nametables on the cartridge
+	if fourScreen {
+		cartridge.VRAM = make([]byte, 2048)
+	}
+
+	if nes20 {
+		cartridge.NES20 = true
+		cartridge.sizesKnown = true
+		cartridge.Mapper |= uint16(header.NumRAM&0x0F) << 8
+		cartridge.Submapper = header.NumRAM >> 4
+		cartridge.PRGRAMSize = nes20RAMSize(header.PRGRAM & 0x0F)
+		cartridge.PRGNVRAMSize = nes20RAMSize(header.PRGRAM >> 4)
+		cartridge.CHRRAMSize = nes20RAMSize(header.CHRRAM & 0x0F)
+		cartridge.CHRNVRAMSize = nes20RAMSize(header.CHRRAM >> 4)
+		cartridge.ConsoleType = header.Control2 & 3
+		if cartridge.ConsoleType == 3 {
+			cartridge.ConsoleType = header.System & 0x0F
+		}
+		cartridge.ExpansionDevice = header.Expansion & 0x3F
+
+		// multi-region games run as NTSC
+		switch header.Timing & 3 {
+		case 1:
+			cartridge.Region = RegionPAL
+		case 3:
+			cartridge.Region = RegionDendy
+		}
+	} else {
+		// iNES only gives the PRG-RAM size, where zero means 8KB
+		ramSize := 8192
+		if !dirty && header.NumRAM != 0 {
+			ramSize = int(header.NumRAM) * 8192
+		}
+		if battery != 0 {
+			cartridge.PRGNVRAMSize = ramSize
+		} else {
+			cartridge.PRGRAMSize = ramSize
+		}
+		if header.NumCHR == 0 {
+			cartridge.CHRRAMSize = 8192
+		}
+		// the game database knows what the header can't say
+		if info, ok := lookupGame(prg, chr); ok {
+			cartridge.applyGameInfo(info)
+		}
 	}
 
+	// provide prg-ram, and chr-ram if there is no chr-rom in the file
+	cartridge.allocateRAM()
+
 	// success
-	return NewCartridge(prg, chr, mapper, mirror, battery), nil
+	return cartridge, nil
+}
+
+// nes20ROMSize decodes a NES 2.0 ROM size from its low byte and high nibble.
+// A high nibble of $F selects the exponent-multiplier notation. Sizes too
+// large to represent return -1.
+func nes20ROMSize(lsb, msb byte, unit int) int {
+	if msb == 0x0F {
+		exponent := uint(lsb >> 2)
+		if exponent > 30 {
+			return -1
+		}
+		multiplier := int(lsb&3)*2 + 1
+		return (1 << exponent) * multiplier
+	}
+	return (int(msb)<<8 | int(lsb)) * unit
+}
+
+// nes20RAMSize decodes a NES 2.0 RAM shift count, where zero means no RAM
+func nes20RAMSize(shift byte) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
 }
@@ -9,30 +9,81 @@ type Mapper interface {
 	Read(address uint16) byte
 	Write(address uint16, value byte)
 	Step()
+	// Reset is called when the console is reset; hard is true on a power
+	// cycle. Most boards never see the reset button and only restore their
+	// power-on register state on a hard reset.
+	Reset(hard bool)
 	Save(encoder *gob.Encoder) error
 	Load(decoder *gob.Decoder) error
 }
 
+// PPUBusWatcher is implemented by mappers that observe the addresses the PPU
+// puts on its bus, such as the MMC3 scanline counter which is clocked by
+// rising edges of A12
+type PPUBusWatcher interface {
+	WatchPPUAddress(address uint16)
+}
+
+// PatternFetchWatcher is implemented by mappers that react to the pattern
+// table fetches the PPU makes while rendering, such as the MMC2 and MMC4
+// tile latches. It is called after each fetch, but not for $2007 accesses.
+type PatternFetchWatcher interface {
+	WatchPatternFetch(address uint16)
+}
+
+// CPUCycleWatcher is implemented by mappers with counters clocked by the CPU,
+// such as cycle-counting IRQs. It is called once per CPU cycle, which is not
+// a whole number of Step calls on PAL.
+type CPUCycleWatcher interface {
+	WatchCPUCycle()
+}
+
+// NameTableMapper is implemented by mappers that decide what memory backs
+// each nametable, replacing the cartridge mirroring mode. Addresses are in
+// $2000-$3EFF.
+type NameTableMapper interface {
+	ReadNameTable(address uint16) byte
+	WriteNameTable(address uint16, value byte)
+}
+
+// ExpansionAreaMapper is implemented by mappers with registers or memory in
+// the CPU expansion area at $4018-$5FFF. Reads of addresses the mapper does
+// not decode should return Console.OpenBus.
+type ExpansionAreaMapper interface {
+	ReadExpansion(address uint16) byte
+	WriteExpansion(address uint16, value byte)
+}
+
+// ExpansionAudio is implemented by mappers with their own sound channels.
+// StepAudio is called once per CPU cycle and AudioOutput is mixed into the
+// APU output on the same scale.
+type ExpansionAudio interface {
+	StepAudio()
+	AudioOutput() float32
+}
+
+// NewMapper creates the mapper for the console's cartridge from the
+// registry, resolving a UNIF board name to its mapper number first
 func NewMapper(console *Console) (Mapper, error) {
 	cartridge := console.Cartridge
-	switch cartridge.Mapper {
-	case 0:
-		return NewMapper2(cartridge), nil
-	case 1:
-		return NewMapper1(cartridge), nil
-	case 2:
-		return NewMapper2(cartridge), nil
-	case 3:
-		return NewMapper3(cartridge), nil
-	case 4:
-		return NewMapper4(console, cartridge), nil
-	case 7:
-		return NewMapper7(cartridge), nil
-	case 40:
-		return NewMapper40(console, cartridge), nil
-	case 225:
-		return NewMapper225(cartridge), nil
+	if cartridge.NSF != nil {
+		return NewMapperNSF(console, cartridge), nil
+	}
+	if cartridge.Board != "" {
+		info, ok := LookupBoard(cartridge.Board)
+		if !ok {
+			return nil, fmt.Errorf("unsupported UNIF board: %s", cartridge.Board)
+		}
+		cartridge.Mapper = info.Mapper
+		cartridge.Submapper = 0
+		if info.Submapper != AnySubmapper {
+			cartridge.Submapper = byte(info.Submapper)
+		}
+	}
+	info, ok := LookupMapper(cartridge.Mapper, cartridge.Submapper)
+	if !ok {
+		err := fmt.Errorf("unsupported mapper: %d", cartridge.Mapper)
+		return nil, err
 	}
-	err := fmt.Errorf("unsupported mapper: %d", cartridge.Mapper)
-	return nil, err
+	return info.New(console, cartridge), nil
 }
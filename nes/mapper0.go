@@ -0,0 +1,61 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// Mapper0 is NROM, with 16KB or 32KB of PRG-ROM and no bank switching. A
+// 16KB PRG-ROM is mirrored into both halves of $8000-$FFFF and writes to
+// the ROM are ignored.
+type Mapper0 struct {
+	*Cartridge
+}
+
+func NewMapper0(cartridge *Cartridge) Mapper {
+	return &Mapper0{cartridge}
+}
+
+func (m *Mapper0) Save(encoder *gob.Encoder) error {
+	return nil
+}
+
+func (m *Mapper0) Load(decoder *gob.Decoder) error {
+	return nil
+}
+
+func (m *Mapper0) Reset(hard bool) {
+}
+
+func (m *Mapper0) Step() {
+}
+
+func (m *Mapper0) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[address]
+	case address >= 0x8000:
+		index := int(address-0x8000) % len(m.PRG)
+		return m.PRG[index]
+	case address >= 0x6000:
+		index := int(address) - 0x6000
+		return m.SRAM[index]
+	default:
+		log.Fatalf("unhandled mapper0 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper0) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		m.CHR[address] = value
+	case address >= 0x8000:
+		// no registers
+	case address >= 0x6000:
+		index := int(address) - 0x6000
+		m.SRAM[index] = value
+	default:
+		log.Fatalf("unhandled mapper0 write at address: 0x%04X", address)
+	}
+}
@@ -52,6 +52,12 @@ func (m *Mapper1) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+func (m *Mapper1) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper1(m.Cartridge).(*Mapper1)
+	}
+}
+
 func (m *Mapper1) Step() {
 }
 
@@ -67,7 +73,7 @@ func (m *Mapper1) Read(address uint16) byte {
 		offset := address % 0x4000
 		return m.PRG[m.prgOffsets[bank]+int(offset)]
 	case address >= 0x6000:
-		return m.SRAM[int(address)-0x6000]
+		return m.SRAM[m.prgRAMOffset()+int(address)-0x6000]
 	default:
 		log.Fatalf("unhandled mapper1 read at address: 0x%04X", address)
 	}
@@ -83,7 +89,7 @@ func (m *Mapper1) Write(address uint16, value byte) {
 	case address >= 0x8000:
 		m.loadRegister(address, value)
 	case address >= 0x6000:
-		m.SRAM[int(address)-0x6000] = value
+		m.SRAM[m.prgRAMOffset()+int(address)-0x6000] = value
 	default:
 		log.Fatalf("unhandled mapper1 write at address: 0x%04X", address)
 	}
@@ -178,6 +184,19 @@ func (m *Mapper1) chrBankOffset(index int) int {
 	return offset
 }
 
+// prgRAMOffset returns the offset of the 8KB PRG-RAM bank at $6000. The
+// CHR bank register selects it on boards with more than 8KB: bit 3 on
+// SOROM (16KB) and bits 2-3 on SXROM (32KB).
+func (m *Mapper1) prgRAMOffset() int {
+	switch len(m.SRAM) {
+	case 0x4000:
+		return int(m.chrBank0>>3&1) * 0x2000
+	case 0x8000:
+		return int(m.chrBank0>>2&3) * 0x2000
+	}
+	return 0
+}
+
 // PRG ROM bank mode (0, 1: switch 32 KB at $8000, ignoring low bit of bank number;
 //                    2: fix first bank at $8000 and switch 16 KB bank at $C000;
 //                    3: fix last bank at $C000 and switch 16 KB bank at $8000)
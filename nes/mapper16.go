@@ -0,0 +1,266 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// Mapper16 is the Bandai FCG family. Mapper 16 is the FCG-1/FCG-2
+// (submapper 4) or the LZ93D50 with a 24C02 EEPROM (submapper 5); without a
+// submapper it answers at the registers of both. Mapper 153 is the LZ93D50
+// with 8KB of battery-backed PRG-RAM and 512KB of PRG-ROM, mapper 157 the
+// Datach Joint ROM System with a 24C02, and mapper 159 the LZ93D50 with a
+// 24C01. EEPROM contents are kept in Cartridge.SRAM, resized to fit, so that
+// they are saved like battery-backed RAM.
+type Mapper16 struct {
+	*Cartridge
+	console    *Console
+	fcg        bool // registers at $6000-$7FFF
+	lz93d50    bool // registers at $8000-$FFFF
+	eeprom     *serialEEPROM
+	prgBank    byte
+	prgOuter   byte // mapper 153: 256KB PRG bank
+	chrBanks   [8]byte
+	ramEnable  bool // mapper 153
+	irqEnable  bool
+	irqCounter uint16
+	irqLatch   uint16
+	irqPending bool
+	prgOffsets [2]int
+	chrOffsets [8]int
+}
+
+func NewMapper16(console *Console, cartridge *Cartridge) Mapper {
+	m := Mapper16{Cartridge: cartridge, console: console}
+	eepromSize := 0
+	switch cartridge.Mapper {
+	case 16:
+		switch cartridge.Submapper {
+		case 4:
+			m.fcg = true
+		case 5:
+			m.lz93d50 = true
+			if !cartridge.sizesKnown || cartridge.PRGNVRAMSize > 0 {
+				eepromSize = 256
+			}
+		default:
+			m.fcg = true
+			m.lz93d50 = true
+			eepromSize = 256
+		}
+	case 153:
+		m.lz93d50 = true
+	case 157:
+		m.lz93d50 = true
+		eepromSize = 256
+	case 159:
+		m.lz93d50 = true
+		eepromSize = 128
+	}
+	if eepromSize > 0 {
+		m.eeprom = newSerialEEPROM(eepromSize == 128)
+		// the EEPROM needs no battery to keep its contents
+		if len(cartridge.SRAM) != eepromSize {
+			cartridge.SRAM = make([]byte, eepromSize)
+		}
+		cartridge.Battery = 1
+	}
+	m.updateOffsets()
+	return &m
+}
+
+func (m *Mapper16) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.prgBank)
+	encoder.Encode(m.prgOuter)
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.ramEnable)
+	encoder.Encode(m.irqEnable)
+	encoder.Encode(m.irqCounter)
+	encoder.Encode(m.irqLatch)
+	encoder.Encode(m.irqPending)
+	encoder.Encode(m.prgOffsets)
+	encoder.Encode(m.chrOffsets)
+	if m.eeprom != nil {
+		m.eeprom.Save(encoder)
+	}
+	return nil
+}
+
+func (m *Mapper16) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.prgBank)
+	decoder.Decode(&m.prgOuter)
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.ramEnable)
+	decoder.Decode(&m.irqEnable)
+	decoder.Decode(&m.irqCounter)
+	decoder.Decode(&m.irqLatch)
+	decoder.Decode(&m.irqPending)
+	decoder.Decode(&m.prgOffsets)
+	decoder.Decode(&m.chrOffsets)
+	if m.eeprom != nil {
+		m.eeprom.Load(decoder)
+	}
+	return nil
+}
+
+func (m *Mapper16) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper16(m.console, m.Cartridge).(*Mapper16)
+	}
+}
+
+func (m *Mapper16) Step() {
+}
+
+// WatchCPUCycle clocks the IRQ counter, which fires when it is clocked at
+// zero and then wraps to $FFFF
+func (m *Mapper16) WatchCPUCycle() {
+	if m.irqEnable {
+		if m.irqCounter == 0 {
+			m.irqPending = true
+		}
+		m.irqCounter--
+	}
+	if m.irqPending {
+		m.console.CPU.triggerIRQ()
+	}
+}
+
+func (m *Mapper16) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		return m.CHR[m.chrOffsets[bank]+int(offset)]
+	case address >= 0x8000:
+		address = address - 0x8000
+		bank := address / 0x4000
+		offset := address % 0x4000
+		return m.PRG[m.prgOffsets[bank]+int(offset)]
+	case address >= 0x6000:
+		if m.Mapper == 153 {
+			if m.ramEnable {
+				return m.SRAM[address-0x6000]
+			}
+			return m.console.openBus
+		}
+		// the EEPROM data line is read in bit 4 and the other bits are
+		// not driven
+		if m.eeprom != nil {
+			return m.console.openBus&0xEF | m.eeprom.read()<<4
+		}
+		return m.console.openBus
+	default:
+		log.Fatalf("unhandled mapper16 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper16) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		m.CHR[m.chrOffsets[bank]+int(offset)] = value
+	case address >= 0x8000:
+		if m.lz93d50 {
+			m.writeRegister(address, value)
+		}
+	case address >= 0x6000:
+		if m.Mapper == 153 && m.ramEnable {
+			m.SRAM[address-0x6000] = value
+		}
+		if m.fcg {
+			m.writeRegister(address, value)
+		}
+	default:
+		log.Fatalf("unhandled mapper16 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper16) writeRegister(address uint16, value byte) {
+	switch register := address & 0x0F; {
+	case register <= 7:
+		m.chrBanks[register] = value
+		if m.Mapper == 153 {
+			m.prgOuter = value & 1
+		}
+		m.updateOffsets()
+	case register == 8:
+		m.prgBank = value & 0x0F
+		m.updateOffsets()
+	case register == 9:
+		m.writeMirror(value)
+	case register == 0xA:
+		m.irqEnable = value&1 != 0
+		m.irqPending = false
+		// the LZ93D50 reloads the counter from its latch; the FCG has no
+		// latch and the counter is written directly
+		if m.lz93d50 {
+			m.irqCounter = m.irqLatch
+		}
+	case register == 0xB:
+		m.irqLatch = m.irqLatch&0xFF00 | uint16(value)
+		if m.fcg {
+			m.irqCounter = m.irqLatch
+		}
+	case register == 0xC:
+		m.irqLatch = m.irqLatch&0x00FF | uint16(value)<<8
+		if m.fcg {
+			m.irqCounter = m.irqLatch
+		}
+	case register == 0xD:
+		if m.Mapper == 153 {
+			m.ramEnable = value&0x20 != 0
+		} else if m.eeprom != nil {
+			m.eeprom.write(m.SRAM, value>>5&1, value>>6&1)
+		}
+	}
+}
+
+func (m *Mapper16) writeMirror(value byte) {
+	switch value & 3 {
+	case 0:
+		m.Cartridge.Mirror = MirrorVertical
+	case 1:
+		m.Cartridge.Mirror = MirrorHorizontal
+	case 2:
+		m.Cartridge.Mirror = MirrorSingle0
+	case 3:
+		m.Cartridge.Mirror = MirrorSingle1
+	}
+}
+
+func (m *Mapper16) prgBankOffset(index int) int {
+	index %= len(m.PRG) / 0x4000
+	offset := index * 0x4000
+	if offset < 0 {
+		offset += len(m.PRG)
+	}
+	return offset
+}
+
+func (m *Mapper16) chrBankOffset(index int) int {
+	index %= len(m.CHR) / 0x0400
+	return index * 0x0400
+}
+
+func (m *Mapper16) updateOffsets() {
+	if m.Mapper == 153 {
+		// each 256KB half of the PRG-ROM ends with its own fixed bank
+		outer := int(m.prgOuter) * 16
+		m.prgOffsets[0] = m.prgBankOffset(outer + int(m.prgBank))
+		m.prgOffsets[1] = m.prgBankOffset(outer + 15)
+	} else {
+		m.prgOffsets[0] = m.prgBankOffset(int(m.prgBank))
+		m.prgOffsets[1] = m.prgBankOffset(-1)
+	}
+	for i, bank := range m.chrBanks {
+		if m.Mapper == 153 || m.Mapper == 157 {
+			// these boards have 8KB of CHR-RAM and no CHR banking
+			m.chrOffsets[i] = i * 0x0400
+		} else {
+			m.chrOffsets[i] = m.chrBankOffset(int(bank))
+		}
+	}
+}
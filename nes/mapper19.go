@@ -0,0 +1,305 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// n163Cycles is the number of CPU cycles the Namco 163 spends updating each
+// sound channel
+const n163Cycles = 15
+
+// Mapper19 is the Namco 163. Its 128 bytes of internal RAM hold both the
+// sound registers and the waveforms, and are kept in Cartridge.ChipRAM so
+// that they are battery-backed along with the PRG-RAM.
+type Mapper19 struct {
+	*Cartridge
+	console      *Console
+	prgBanks     [3]byte
+	chrBanks     [12]byte // eight pattern table banks, then four nametables
+	chrRAMSelect byte     // $E800 bits 6 and 7
+	soundDisable bool
+	ramAddress   byte // $F800: internal RAM address, bit 7 auto-increment
+	ramProtect   byte // $F800: PRG-RAM write protection
+	prgOffsets   [4]int
+	irqCounter   uint16
+	irqEnable    bool
+	irqPending   bool
+	channel      int
+	audioCycles  int
+	outputs      [8]int
+}
+
+func NewMapper19(console *Console, cartridge *Cartridge) Mapper {
+	m := Mapper19{Cartridge: cartridge, console: console}
+	if len(cartridge.ChipRAM) != 0x80 {
+		cartridge.ChipRAM = make([]byte, 0x80)
+	}
+	m.channel = 7
+	m.updateOffsets()
+	return &m
+}
+
+func (m *Mapper19) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.prgBanks)
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.chrRAMSelect)
+	encoder.Encode(m.soundDisable)
+	encoder.Encode(m.ramAddress)
+	encoder.Encode(m.ramProtect)
+	encoder.Encode(m.prgOffsets)
+	encoder.Encode(m.irqCounter)
+	encoder.Encode(m.irqEnable)
+	encoder.Encode(m.irqPending)
+	encoder.Encode(m.channel)
+	encoder.Encode(m.audioCycles)
+	encoder.Encode(m.outputs)
+	return nil
+}
+
+func (m *Mapper19) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.prgBanks)
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.chrRAMSelect)
+	decoder.Decode(&m.soundDisable)
+	decoder.Decode(&m.ramAddress)
+	decoder.Decode(&m.ramProtect)
+	decoder.Decode(&m.prgOffsets)
+	decoder.Decode(&m.irqCounter)
+	decoder.Decode(&m.irqEnable)
+	decoder.Decode(&m.irqPending)
+	decoder.Decode(&m.channel)
+	decoder.Decode(&m.audioCycles)
+	decoder.Decode(&m.outputs)
+	return nil
+}
+
+func (m *Mapper19) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper19(m.console, m.Cartridge).(*Mapper19)
+	}
+}
+
+func (m *Mapper19) Step() {
+}
+
+// WatchCPUCycle clocks the IRQ counter, which counts up to $7FFF and then
+// stops with an IRQ pending
+func (m *Mapper19) WatchCPUCycle() {
+	if m.irqEnable && m.irqCounter < 0x7FFF {
+		m.irqCounter++
+		if m.irqCounter == 0x7FFF {
+			m.irqPending = true
+		}
+	}
+	if m.irqPending {
+		m.console.CPU.triggerIRQ()
+	}
+}
+
+func (m *Mapper19) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.readCHR(int(address/0x0400), address%0x0400)
+	case address >= 0x8000:
+		address = address - 0x8000
+		bank := address / 0x2000
+		offset := address % 0x2000
+		return m.PRG[m.prgOffsets[bank]+int(offset)]
+	case address >= 0x6000:
+		return m.SRAM[int(address)-0x6000]
+	default:
+		log.Fatalf("unhandled mapper19 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper19) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		m.writeCHR(int(address/0x0400), address%0x0400, value)
+	case address >= 0x8000:
+		m.writeRegister(address, value)
+	case address >= 0x6000:
+		// writes need $4x in the upper bits of $F800 and the 2KB window's
+		// protect bit clear
+		window := byte(1) << ((address - 0x6000) / 0x0800)
+		if m.ramProtect&0xF0 == 0x40 && m.ramProtect&window == 0 {
+			m.SRAM[int(address)-0x6000] = value
+		}
+	default:
+		log.Fatalf("unhandled mapper19 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper19) ReadExpansion(address uint16) byte {
+	switch {
+	case address >= 0x5800:
+		value := byte(m.irqCounter >> 8)
+		if m.irqEnable {
+			value |= 0x80
+		}
+		return value
+	case address >= 0x5000:
+		return byte(m.irqCounter)
+	case address >= 0x4800:
+		value := m.ChipRAM[m.ramAddress&0x7F]
+		m.stepRAMAddress()
+		return value
+	}
+	return m.console.openBus
+}
+
+func (m *Mapper19) WriteExpansion(address uint16, value byte) {
+	switch {
+	case address >= 0x5800:
+		m.irqCounter = m.irqCounter&0x00FF | uint16(value&0x7F)<<8
+		m.irqEnable = value&0x80 != 0
+		m.irqPending = false
+	case address >= 0x5000:
+		m.irqCounter = m.irqCounter&0x7F00 | uint16(value)
+		m.irqPending = false
+	case address >= 0x4800:
+		m.ChipRAM[m.ramAddress&0x7F] = value
+		m.stepRAMAddress()
+	}
+}
+
+func (m *Mapper19) stepRAMAddress() {
+	if m.ramAddress&0x80 != 0 {
+		m.ramAddress = 0x80 | (m.ramAddress+1)&0x7F
+	}
+}
+
+func (m *Mapper19) writeRegister(address uint16, value byte) {
+	switch {
+	case address < 0xE000:
+		m.chrBanks[(address-0x8000)/0x0800] = value
+	case address < 0xE800:
+		m.prgBanks[0] = value & 0x3F
+		m.soundDisable = value&0x40 != 0
+		m.updateOffsets()
+	case address < 0xF000:
+		m.prgBanks[1] = value & 0x3F
+		m.chrRAMSelect = value & 0xC0
+		m.updateOffsets()
+	case address < 0xF800:
+		m.prgBanks[2] = value & 0x3F
+		m.updateOffsets()
+	default:
+		m.ramAddress = value
+		m.ramProtect = value
+	}
+}
+
+// readCHR reads a 1KB bank of pattern or nametable space. Bank values of
+// $E0 and above select console nametable RAM instead of CHR-ROM, except in
+// pattern tables whose $E800 bit asks for ROM only.
+func (m *Mapper19) readCHR(bank int, offset uint16) byte {
+	if ram, ok := m.nameTableRAM(bank); ok {
+		return ram[offset]
+	}
+	index := int(m.chrBanks[bank]) % (len(m.CHR) / 0x0400)
+	return m.CHR[index*0x0400+int(offset)]
+}
+
+func (m *Mapper19) writeCHR(bank int, offset uint16, value byte) {
+	if ram, ok := m.nameTableRAM(bank); ok {
+		ram[offset] = value
+		return
+	}
+	index := int(m.chrBanks[bank]) % (len(m.CHR) / 0x0400)
+	m.CHR[index*0x0400+int(offset)] = value
+}
+
+func (m *Mapper19) nameTableRAM(bank int) ([]byte, bool) {
+	value := m.chrBanks[bank]
+	if value < 0xE0 {
+		return nil, false
+	}
+	if bank < 8 && m.chrRAMSelect&(0x40<<uint(bank/4)) != 0 {
+		return nil, false
+	}
+	page := int(value&1) * 0x0400
+	return m.console.PPU.nameTableData[page : page+0x0400], true
+}
+
+func (m *Mapper19) ReadNameTable(address uint16) byte {
+	table := int(address-0x2000) / 0x0400 % 4
+	return m.readCHR(8+table, (address-0x2000)%0x0400)
+}
+
+func (m *Mapper19) WriteNameTable(address uint16, value byte) {
+	table := int(address-0x2000) / 0x0400 % 4
+	m.writeCHR(8+table, (address-0x2000)%0x0400, value)
+}
+
+func (m *Mapper19) prgBankOffset(index int) int {
+	index %= len(m.PRG) / 0x2000
+	offset := index * 0x2000
+	if offset < 0 {
+		offset += len(m.PRG)
+	}
+	return offset
+}
+
+func (m *Mapper19) updateOffsets() {
+	for i, bank := range m.prgBanks {
+		m.prgOffsets[i] = m.prgBankOffset(int(bank))
+	}
+	m.prgOffsets[3] = m.prgBankOffset(-1)
+}
+
+// StepAudio updates one channel every 15 CPU cycles, cycling from channel
+// 7 down through as many channels as are enabled in $7F
+func (m *Mapper19) StepAudio() {
+	if m.soundDisable {
+		return
+	}
+	m.audioCycles++
+	if m.audioCycles < n163Cycles {
+		return
+	}
+	m.audioCycles = 0
+	m.updateChannel(m.channel)
+	m.channel--
+	if m.channel < 8-m.channelCount() {
+		m.channel = 7
+	}
+}
+
+func (m *Mapper19) channelCount() int {
+	return int(m.ChipRAM[0x7F]>>4&7) + 1
+}
+
+// updateChannel advances a channel's 24-bit phase and looks up its next
+// 4-bit sample in the waveform stored in internal RAM
+func (m *Mapper19) updateChannel(channel int) {
+	registers := m.ChipRAM[0x40+channel*8 : 0x48+channel*8]
+	frequency := int(registers[0]) | int(registers[2])<<8 | int(registers[4]&3)<<16
+	phase := int(registers[1]) | int(registers[3])<<8 | int(registers[5])<<16
+	length := (256 - int(registers[4]&0xFC)) << 16
+	phase = (phase + frequency) % length
+	registers[1] = byte(phase)
+	registers[3] = byte(phase >> 8)
+	registers[5] = byte(phase >> 16)
+	index := (phase>>16 + int(registers[6])) & 0xFF
+	sample := m.ChipRAM[index/2] >> (uint(index&1) * 4) & 15
+	m.outputs[channel] = (int(sample) - 8) * int(registers[7]&15)
+}
+
+// AudioOutput averages the enabled channels. The chip plays them one at a
+// time through a single DAC, and averaging stands in for the high pitched
+// whine that switching would otherwise alias into at the output rate.
+func (m *Mapper19) AudioOutput() float32 {
+	if m.soundDisable {
+		return 0
+	}
+	count := m.channelCount()
+	total := 0
+	for channel := 8 - count; channel < 8; channel++ {
+		total += m.outputs[channel]
+	}
+	// a lone channel at full volume is roughly twice as loud as a pulse
+	return float32(total) / float32(count) * pulseTable[15] / 60
+}
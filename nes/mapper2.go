@@ -5,18 +5,23 @@ import (
 	"log"
 )
 
+// Mapper2 is UxROM. Submapper 2 has bus conflicts: the ROM drives the data
+// bus during writes, so the bank latched is the AND of the value written
+// and the ROM byte at that address. Submappers 0 and 1 have none.
 type Mapper2 struct {
 	*Cartridge
-	prgBanks int
-	prgBank1 int
-	prgBank2 int
+	prgBanks     int
+	prgBank1     int
+	prgBank2     int
+	busConflicts bool
 }
 
 func NewMapper2(cartridge *Cartridge) Mapper {
 	prgBanks := len(cartridge.PRG) / 0x4000
 	prgBank1 := 0
 	prgBank2 := prgBanks - 1
-	return &Mapper2{cartridge, prgBanks, prgBank1, prgBank2}
+	busConflicts := cartridge.Submapper == 2
+	return &Mapper2{cartridge, prgBanks, prgBank1, prgBank2, busConflicts}
 }
 
 func (m *Mapper2) Save(encoder *gob.Encoder) error {
@@ -33,6 +38,12 @@ func (m *Mapper2) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+func (m *Mapper2) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper2(m.Cartridge).(*Mapper2)
+	}
+}
+
 func (m *Mapper2) Step() {
 }
 
@@ -60,6 +71,9 @@ func (m *Mapper2) Write(address uint16, value byte) {
 	case address < 0x2000:
 		m.CHR[address] = value
 	case address >= 0x8000:
+		if m.busConflicts {
+			value &= m.Read(address)
+		}
 		m.prgBank1 = int(value) % m.prgBanks
 	case address >= 0x6000:
 		index := int(address) - 0x6000
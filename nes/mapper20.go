@@ -0,0 +1,413 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+const (
+	// fdsInsertDelay is how many CPU cycles the drive stays empty while the
+	// disk is flipped, long enough for the BIOS to notice
+	fdsInsertDelay = 1000000
+	// fdsSpinUp is the delay in CPU cycles before the head reaches the
+	// start of the disk
+	fdsSpinUp = 50000
+	// fdsByteCycles is the time in CPU cycles to read or write one byte
+	fdsByteCycles = 150
+)
+
+// Mapper20 is the Famicom Disk System RAM adapter, loaded from .fds images.
+// iNES reserved mapper 20 for it. It provides 32KB of PRG-RAM, 8KB of
+// CHR-RAM, the BIOS at $E000, a timer IRQ, the disk drive interface and a
+// wavetable sound channel.
+type Mapper20 struct {
+	*Cartridge
+	console     *Console
+	diskEnable  bool
+	soundEnable bool
+
+	// timer IRQ
+	irqReload  uint16
+	irqCounter uint16
+	irqEnable  bool
+	irqRepeat  bool
+	timerIRQ   bool
+
+	// disk drive; side is -1 with no disk inserted
+	side             int
+	lastSide         int
+	insertDelay      int
+	motorOn          bool
+	resetTransfer    bool
+	readMode         bool
+	crcControl       bool
+	transferEnable   bool
+	diskIRQEnable    bool
+	endOfHead        bool
+	scanning         bool
+	delay            int
+	position         int
+	gapEnded         bool
+	transferComplete bool
+	readData         byte
+	writeData        byte
+	crcByte          int
+	diskIRQ          bool
+
+	audio fdsAudio
+}
+
+func NewMapper20(console *Console, cartridge *Cartridge) Mapper {
+	m := Mapper20{Cartridge: cartridge, console: console}
+	m.endOfHead = true
+	m.audio.reset()
+	return &m
+}
+
+func (m *Mapper20) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.diskEnable)
+	encoder.Encode(m.soundEnable)
+	encoder.Encode(m.irqReload)
+	encoder.Encode(m.irqCounter)
+	encoder.Encode(m.irqEnable)
+	encoder.Encode(m.irqRepeat)
+	encoder.Encode(m.timerIRQ)
+	encoder.Encode(m.side)
+	encoder.Encode(m.lastSide)
+	encoder.Encode(m.insertDelay)
+	encoder.Encode(m.motorOn)
+	encoder.Encode(m.resetTransfer)
+	encoder.Encode(m.readMode)
+	encoder.Encode(m.crcControl)
+	encoder.Encode(m.transferEnable)
+	encoder.Encode(m.diskIRQEnable)
+	encoder.Encode(m.endOfHead)
+	encoder.Encode(m.scanning)
+	encoder.Encode(m.delay)
+	encoder.Encode(m.position)
+	encoder.Encode(m.gapEnded)
+	encoder.Encode(m.transferComplete)
+	encoder.Encode(m.readData)
+	encoder.Encode(m.writeData)
+	encoder.Encode(m.crcByte)
+	encoder.Encode(m.diskIRQ)
+	m.audio.Save(encoder)
+	return nil
+}
+
+func (m *Mapper20) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.diskEnable)
+	decoder.Decode(&m.soundEnable)
+	decoder.Decode(&m.irqReload)
+	decoder.Decode(&m.irqCounter)
+	decoder.Decode(&m.irqEnable)
+	decoder.Decode(&m.irqRepeat)
+	decoder.Decode(&m.timerIRQ)
+	decoder.Decode(&m.side)
+	decoder.Decode(&m.lastSide)
+	decoder.Decode(&m.insertDelay)
+	decoder.Decode(&m.motorOn)
+	decoder.Decode(&m.resetTransfer)
+	decoder.Decode(&m.readMode)
+	decoder.Decode(&m.crcControl)
+	decoder.Decode(&m.transferEnable)
+	decoder.Decode(&m.diskIRQEnable)
+	decoder.Decode(&m.endOfHead)
+	decoder.Decode(&m.scanning)
+	decoder.Decode(&m.delay)
+	decoder.Decode(&m.position)
+	decoder.Decode(&m.gapEnded)
+	decoder.Decode(&m.transferComplete)
+	decoder.Decode(&m.readData)
+	decoder.Decode(&m.writeData)
+	decoder.Decode(&m.crcByte)
+	decoder.Decode(&m.diskIRQ)
+	m.audio.Load(decoder)
+	return nil
+}
+
+// Reset keeps the disk in the drive across a power cycle
+func (m *Mapper20) Reset(hard bool) {
+	if hard {
+		side, lastSide := m.side, m.lastSide
+		*m = *NewMapper20(m.console, m.Cartridge).(*Mapper20)
+		m.side, m.lastSide = side, lastSide
+	}
+}
+
+// EjectDisk takes the disk out of the drive, or puts it back in
+func (m *Mapper20) EjectDisk() {
+	if m.side >= 0 {
+		m.lastSide = m.side
+		m.side = -1
+	} else {
+		m.side = m.lastSide
+	}
+	m.insertDelay = 0
+}
+
+// FlipDisk takes the disk out and, after a moment, inserts the next side
+func (m *Mapper20) FlipDisk() {
+	if m.side >= 0 {
+		m.lastSide = m.side
+	}
+	m.side = -1
+	m.lastSide = (m.lastSide + 1) % len(m.Disk)
+	m.insertDelay = fdsInsertDelay
+}
+
+// DiskSide returns the inserted disk side, or -1 if the drive is empty
+func (m *Mapper20) DiskSide() int {
+	return m.side
+}
+
+func (m *Mapper20) Step() {
+}
+
+// WatchCPUCycle runs the timer IRQ and the disk drive, both clocked by the
+// CPU
+func (m *Mapper20) WatchCPUCycle() {
+	m.stepTimer()
+	m.stepDrive()
+	if m.timerIRQ || m.diskIRQ {
+		m.console.CPU.triggerIRQ()
+	}
+}
+
+func (m *Mapper20) stepTimer() {
+	if !m.irqEnable {
+		return
+	}
+	if m.irqCounter == 0 {
+		m.timerIRQ = true
+		m.irqCounter = m.irqReload
+		if !m.irqRepeat {
+			m.irqEnable = false
+		}
+	} else {
+		m.irqCounter--
+	}
+}
+
+// stepDrive moves the disk under the head, transferring a byte every
+// fdsByteCycles while the motor runs
+func (m *Mapper20) stepDrive() {
+	if m.insertDelay > 0 {
+		m.insertDelay--
+		if m.insertDelay == 0 {
+			m.side = m.lastSide
+		}
+	}
+	if m.side < 0 || !m.motorOn {
+		m.endOfHead = true
+		m.scanning = false
+		return
+	}
+	if m.resetTransfer && !m.scanning {
+		return
+	}
+	if m.endOfHead {
+		m.delay = fdsSpinUp
+		m.endOfHead = false
+		m.position = 0
+		m.gapEnded = false
+		return
+	}
+	if m.delay > 0 {
+		m.delay--
+		return
+	}
+	m.scanning = true
+	disk := m.Disk[m.side]
+	if m.readMode {
+		m.readByte(disk[m.position])
+	} else {
+		disk[m.position] = m.nextWriteByte()
+	}
+	m.position++
+	if m.position >= len(disk) {
+		m.motorOn = false
+		m.endOfHead = true
+	} else {
+		m.delay = fdsByteCycles
+	}
+}
+
+// readByte hands a byte from the disk to the CPU once the start mark ending
+// the gap before a block has gone by
+func (m *Mapper20) readByte(value byte) {
+	irq := m.diskIRQEnable
+	if !m.transferEnable {
+		m.gapEnded = false
+	} else if value != 0 && !m.gapEnded {
+		// the start mark itself is latched without an IRQ
+		m.gapEnded = true
+		irq = false
+	}
+	if m.gapEnded {
+		m.readData = value
+		m.transferComplete = true
+		if irq {
+			m.diskIRQ = true
+		}
+	}
+}
+
+// nextWriteByte returns the byte written under the head: the CPU's data, a
+// gap while transfers are off, or the block CRC in CRC mode
+func (m *Mapper20) nextWriteByte() byte {
+	m.gapEnded = false
+	if m.crcControl {
+		// CRCs are never checked, so write the same placeholder the loader
+		// puts after each block
+		m.crcByte++
+		if m.crcByte == 1 {
+			return 0x4D
+		}
+		return 0x62
+	}
+	m.crcByte = 0
+	m.transferComplete = true
+	if m.diskIRQEnable {
+		m.diskIRQ = true
+	}
+	if !m.transferEnable {
+		return 0
+	}
+	return m.writeData
+}
+
+func (m *Mapper20) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[address]
+	case address >= 0xE000:
+		return m.PRG[address-0xE000]
+	case address >= 0x6000:
+		return m.SRAM[address-0x6000]
+	default:
+		log.Fatalf("unhandled mapper20 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper20) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		m.CHR[address] = value
+	case address >= 0xE000:
+		// BIOS ROM
+	case address >= 0x6000:
+		m.SRAM[address-0x6000] = value
+	default:
+		log.Fatalf("unhandled mapper20 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper20) ReadExpansion(address uint16) byte {
+	switch {
+	case address == 0x4030:
+		return m.readStatus()
+	case address == 0x4031:
+		m.transferComplete = false
+		m.diskIRQ = false
+		return m.readData
+	case address == 0x4032:
+		return m.readDriveStatus()
+	case address == 0x4033:
+		// battery good
+		return 0x80
+	case address >= 0x4040 && address < 0x40A0:
+		return m.audio.readRegister(address)
+	}
+	return m.console.openBus
+}
+
+func (m *Mapper20) readStatus() byte {
+	var value byte
+	if m.timerIRQ {
+		value |= 0x01
+	}
+	if m.transferComplete {
+		value |= 0x02
+	}
+	if m.endOfHead {
+		value |= 0x40
+	}
+	m.timerIRQ = false
+	m.diskIRQ = false
+	m.transferComplete = false
+	return value
+}
+
+func (m *Mapper20) readDriveStatus() byte {
+	var value byte
+	if m.side < 0 {
+		// no disk, not ready and not writable
+		value |= 0x07
+	} else if !m.scanning {
+		value |= 0x02
+	}
+	return value
+}
+
+func (m *Mapper20) WriteExpansion(address uint16, value byte) {
+	switch {
+	case address == 0x4020:
+		m.irqReload = m.irqReload&0xFF00 | uint16(value)
+	case address == 0x4021:
+		m.irqReload = m.irqReload&0x00FF | uint16(value)<<8
+	case address == 0x4022:
+		m.irqRepeat = value&1 != 0
+		m.irqEnable = value&2 != 0 && m.diskEnable
+		m.timerIRQ = false
+		if m.irqEnable {
+			m.irqCounter = m.irqReload
+		}
+	case address == 0x4023:
+		m.diskEnable = value&1 != 0
+		m.soundEnable = value&2 != 0
+		if !m.diskEnable {
+			m.irqEnable = false
+			m.timerIRQ = false
+			m.diskIRQ = false
+		}
+	case address == 0x4024:
+		if m.diskEnable {
+			m.writeData = value
+			m.transferComplete = false
+			m.diskIRQ = false
+		}
+	case address == 0x4025:
+		if m.diskEnable {
+			m.writeControl(value)
+		}
+	case address >= 0x4040 && address < 0x40A0:
+		if m.soundEnable {
+			m.audio.writeRegister(address, value)
+		}
+	}
+}
+
+func (m *Mapper20) writeControl(value byte) {
+	m.motorOn = value&0x01 != 0
+	m.resetTransfer = value&0x02 != 0
+	m.readMode = value&0x04 != 0
+	if value&0x08 != 0 {
+		m.Cartridge.Mirror = MirrorHorizontal
+	} else {
+		m.Cartridge.Mirror = MirrorVertical
+	}
+	m.crcControl = value&0x10 != 0
+	m.transferEnable = value&0x40 != 0
+	m.diskIRQEnable = value&0x80 != 0
+	m.diskIRQ = false
+}
+
+func (m *Mapper20) StepAudio() {
+	m.audio.step()
+}
+
+func (m *Mapper20) AudioOutput() float32 {
+	return m.audio.output()
+}
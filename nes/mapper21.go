@@ -0,0 +1,259 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// vrcVariant describes how a VRC2/VRC4 board is wired: which CPU address
+// lines drive the chip's register select pins, and whether it is a VRC2
+type vrcVariant struct {
+	a0, a1 uint16 // address lines wired to register select pins A0 and A1
+	vrc2   bool
+}
+
+// vrcVariants maps a mapper and NES 2.0 submapper to its board wiring.
+// Submapper 0 means unknown; those entries OR together the lines of every
+// board sharing the mapper number, as games only write to one of them.
+var vrcVariants = map[uint16][]vrcVariant{
+	21: {
+		{0x02 | 0x40, 0x04 | 0x80, false}, // VRC4a or VRC4c
+		{0x02, 0x04, false},               // VRC4a
+		{0x40, 0x80, false},               // VRC4c
+	},
+	22: {
+		{0x02, 0x01, true}, // VRC2a
+	},
+	23: {
+		{0x01 | 0x04, 0x02 | 0x08, false}, // VRC4f or VRC4e
+		{0x01, 0x02, false},               // VRC4f
+		{0x04, 0x08, false},               // VRC4e
+		{0x01, 0x02, true},                // VRC2b
+	},
+	25: {
+		{0x02 | 0x08, 0x01 | 0x04, false}, // VRC4b or VRC4d
+		{0x02, 0x01, false},               // VRC4b
+		{0x08, 0x04, false},               // VRC4d
+		{0x02, 0x01, true},                // VRC2c
+	},
+}
+
+// Mapper21 is the Konami VRC2 and VRC4, used by mappers 21, 22, 23 and 25
+type Mapper21 struct {
+	*Cartridge
+	console    *Console
+	variant    vrcVariant
+	hasRAM     bool
+	prgBanks   [2]byte
+	prgMode    byte
+	chrBanks   [8]uint16
+	prgOffsets [4]int
+	chrOffsets [8]int
+	irq        vrcIRQ
+	latch      byte // VRC2 microwire latch at $6000
+}
+
+func NewMapper21(console *Console, cartridge *Cartridge) Mapper {
+	m := Mapper21{Cartridge: cartridge, console: console}
+	variants := vrcVariants[cartridge.Mapper]
+	m.variant = variants[0]
+	if int(cartridge.Submapper) < len(variants) {
+		m.variant = variants[cartridge.Submapper]
+	}
+	m.hasRAM = cartridge.Battery != 0 ||
+		(cartridge.sizesKnown && cartridge.PRGRAMSize+cartridge.PRGNVRAMSize > 0)
+	m.updateOffsets()
+	return &m
+}
+
+func (m *Mapper21) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.prgBanks)
+	encoder.Encode(m.prgMode)
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.prgOffsets)
+	encoder.Encode(m.chrOffsets)
+	m.irq.Save(encoder)
+	encoder.Encode(m.latch)
+	return nil
+}
+
+func (m *Mapper21) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.prgBanks)
+	decoder.Decode(&m.prgMode)
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.prgOffsets)
+	decoder.Decode(&m.chrOffsets)
+	m.irq.Load(decoder)
+	decoder.Decode(&m.latch)
+	return nil
+}
+
+func (m *Mapper21) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper21(m.console, m.Cartridge).(*Mapper21)
+	}
+}
+
+func (m *Mapper21) Step() {
+}
+
+func (m *Mapper21) WatchCPUCycle() {
+	if !m.variant.vrc2 {
+		m.irq.step(m.console)
+	}
+}
+
+func (m *Mapper21) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		return m.CHR[m.chrOffsets[bank]+int(offset)]
+	case address >= 0x8000:
+		address = address - 0x8000
+		bank := address / 0x2000
+		offset := address % 0x2000
+		return m.PRG[m.prgOffsets[bank]+int(offset)]
+	case address >= 0x6000:
+		if m.variant.vrc2 && !m.hasRAM {
+			// only bit 0 of the latch is driven
+			if address < 0x7000 {
+				return m.console.openBus&0xFE | m.latch
+			}
+			return m.console.openBus
+		}
+		return m.SRAM[int(address)-0x6000]
+	default:
+		log.Fatalf("unhandled mapper21 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper21) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		m.CHR[m.chrOffsets[bank]+int(offset)] = value
+	case address >= 0x8000:
+		m.writeRegister(address, value)
+	case address >= 0x6000:
+		if m.variant.vrc2 && !m.hasRAM {
+			if address < 0x7000 {
+				m.latch = value & 1
+			}
+			return
+		}
+		m.SRAM[int(address)-0x6000] = value
+	default:
+		log.Fatalf("unhandled mapper21 write at address: 0x%04X", address)
+	}
+}
+
+// register translates a CPU address into the chip's own register address,
+// $x000-$x003, according to the board wiring
+func (m *Mapper21) register(address uint16) uint16 {
+	register := address & 0xF000
+	if address&m.variant.a0 != 0 {
+		register |= 1
+	}
+	if address&m.variant.a1 != 0 {
+		register |= 2
+	}
+	return register
+}
+
+func (m *Mapper21) writeRegister(address uint16, value byte) {
+	register := m.register(address)
+	switch {
+	case register <= 0x8003:
+		m.prgBanks[0] = value & 0x1F
+		m.updateOffsets()
+	case register <= 0x9003:
+		if m.variant.vrc2 || register <= 0x9001 {
+			m.writeMirror(value)
+		} else {
+			m.prgMode = (value >> 1) & 1
+			m.updateOffsets()
+		}
+	case register <= 0xA003:
+		m.prgBanks[1] = value & 0x1F
+		m.updateOffsets()
+	case register <= 0xE003:
+		m.writeCHRBank(register, value)
+	case m.variant.vrc2:
+		// VRC2 has no IRQ counter
+	case register == 0xF000:
+		m.irq.writeLatch(m.irq.latch&0xF0 | value&0x0F)
+	case register == 0xF001:
+		m.irq.writeLatch(m.irq.latch&0x0F | value<<4)
+	case register == 0xF002:
+		m.irq.writeControl(value)
+	case register == 0xF003:
+		m.irq.acknowledge()
+	}
+}
+
+// writeCHRBank sets the low or high bits of a 1KB CHR bank. Each bank has a
+// pair of registers, starting with banks 0 and 1 at $B000-$B003.
+func (m *Mapper21) writeCHRBank(register uint16, value byte) {
+	index := (register-0xB000)>>12*2 | (register>>1)&1
+	bank := m.chrBanks[index]
+	if register&1 == 0 {
+		bank = bank&0x1F0 | uint16(value&0x0F)
+	} else {
+		bank = bank&0x00F | uint16(value&0x1F)<<4
+	}
+	m.chrBanks[index] = bank
+	m.updateOffsets()
+}
+
+func (m *Mapper21) writeMirror(value byte) {
+	if m.variant.vrc2 {
+		value &= 1
+	}
+	switch value & 3 {
+	case 0:
+		m.Cartridge.Mirror = MirrorVertical
+	case 1:
+		m.Cartridge.Mirror = MirrorHorizontal
+	case 2:
+		m.Cartridge.Mirror = MirrorSingle0
+	case 3:
+		m.Cartridge.Mirror = MirrorSingle1
+	}
+}
+
+func (m *Mapper21) prgBankOffset(index int) int {
+	index %= len(m.PRG) / 0x2000
+	offset := index * 0x2000
+	if offset < 0 {
+		offset += len(m.PRG)
+	}
+	return offset
+}
+
+func (m *Mapper21) chrBankOffset(index int) int {
+	index %= len(m.CHR) / 0x0400
+	return index * 0x0400
+}
+
+func (m *Mapper21) updateOffsets() {
+	switch m.prgMode {
+	case 0:
+		m.prgOffsets[0] = m.prgBankOffset(int(m.prgBanks[0]))
+		m.prgOffsets[2] = m.prgBankOffset(-2)
+	case 1:
+		m.prgOffsets[0] = m.prgBankOffset(-2)
+		m.prgOffsets[2] = m.prgBankOffset(int(m.prgBanks[0]))
+	}
+	m.prgOffsets[1] = m.prgBankOffset(int(m.prgBanks[1]))
+	m.prgOffsets[3] = m.prgBankOffset(-1)
+	for i, bank := range m.chrBanks {
+		// VRC2a ignores the lowest bit of its CHR bank numbers
+		if m.Cartridge.Mapper == 22 {
+			bank >>= 1
+		}
+		m.chrOffsets[i] = m.chrBankOffset(int(bank))
+	}
+}
@@ -34,6 +34,12 @@ func (m *Mapper225) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+func (m *Mapper225) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper225(m.Cartridge).(*Mapper225)
+	}
+}
+
 func (m *Mapper225) Step() {
 }
 
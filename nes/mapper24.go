@@ -0,0 +1,391 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// Mapper24 is the Konami VRC6. Mapper 26 is the same chip with address
+// lines A0 and A1 swapped. Nametables from CHR-ROM are not supported; the
+// PPU banking modes only select CHR layouts and mirroring.
+type Mapper24 struct {
+	*Cartridge
+	console    *Console
+	swapped    bool
+	prgBank16  byte
+	prgBank8   byte
+	chrBanks   [8]byte
+	bankMode   byte
+	prgOffsets [4]int
+	chrOffsets [8]int
+	irq        vrcIRQ
+	pulse1     vrc6Pulse
+	pulse2     vrc6Pulse
+	saw        vrc6Saw
+	halt       bool
+	shift      uint
+}
+
+func NewMapper24(console *Console, cartridge *Cartridge) Mapper {
+	m := Mapper24{Cartridge: cartridge, console: console}
+	m.swapped = cartridge.Mapper == 26
+	m.updateOffsets()
+	return &m
+}
+
+func (m *Mapper24) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.prgBank16)
+	encoder.Encode(m.prgBank8)
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.bankMode)
+	encoder.Encode(m.prgOffsets)
+	encoder.Encode(m.chrOffsets)
+	m.irq.Save(encoder)
+	m.pulse1.Save(encoder)
+	m.pulse2.Save(encoder)
+	m.saw.Save(encoder)
+	encoder.Encode(m.halt)
+	encoder.Encode(m.shift)
+	return nil
+}
+
+func (m *Mapper24) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.prgBank16)
+	decoder.Decode(&m.prgBank8)
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.bankMode)
+	decoder.Decode(&m.prgOffsets)
+	decoder.Decode(&m.chrOffsets)
+	m.irq.Load(decoder)
+	m.pulse1.Load(decoder)
+	m.pulse2.Load(decoder)
+	m.saw.Load(decoder)
+	decoder.Decode(&m.halt)
+	decoder.Decode(&m.shift)
+	return nil
+}
+
+func (m *Mapper24) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper24(m.console, m.Cartridge).(*Mapper24)
+	}
+}
+
+func (m *Mapper24) Step() {
+}
+
+func (m *Mapper24) WatchCPUCycle() {
+	m.irq.step(m.console)
+}
+
+func (m *Mapper24) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		return m.CHR[m.chrOffsets[bank]+int(offset)]
+	case address >= 0x8000:
+		address = address - 0x8000
+		bank := address / 0x2000
+		offset := address % 0x2000
+		return m.PRG[m.prgOffsets[bank]+int(offset)]
+	case address >= 0x6000:
+		if m.bankMode&0x80 != 0 {
+			return m.SRAM[int(address)-0x6000]
+		}
+		return m.console.openBus
+	default:
+		log.Fatalf("unhandled mapper24 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper24) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		m.CHR[m.chrOffsets[bank]+int(offset)] = value
+	case address >= 0x8000:
+		m.writeRegister(address, value)
+	case address >= 0x6000:
+		if m.bankMode&0x80 != 0 {
+			m.SRAM[int(address)-0x6000] = value
+		}
+	default:
+		log.Fatalf("unhandled mapper24 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper24) writeRegister(address uint16, value byte) {
+	if m.swapped {
+		address = address&0xFFFC | address>>1&1 | address<<1&2
+	}
+	switch address & 0xF003 {
+	case 0x8000, 0x8001, 0x8002, 0x8003:
+		m.prgBank16 = value & 0x0F
+		m.updateOffsets()
+	case 0x9000:
+		m.pulse1.writeControl(value)
+	case 0x9001:
+		m.pulse1.writePeriodLow(value)
+	case 0x9002:
+		m.pulse1.writePeriodHigh(value)
+	case 0x9003:
+		m.halt = value&1 == 1
+		switch {
+		case value&4 == 4:
+			m.shift = 8
+		case value&2 == 2:
+			m.shift = 4
+		default:
+			m.shift = 0
+		}
+	case 0xA000:
+		m.pulse2.writeControl(value)
+	case 0xA001:
+		m.pulse2.writePeriodLow(value)
+	case 0xA002:
+		m.pulse2.writePeriodHigh(value)
+	case 0xB000:
+		m.saw.writeRate(value)
+	case 0xB001:
+		m.saw.writePeriodLow(value)
+	case 0xB002:
+		m.saw.writePeriodHigh(value)
+	case 0xB003:
+		m.bankMode = value
+		m.writeMirror(value)
+		m.updateOffsets()
+	case 0xC000, 0xC001, 0xC002, 0xC003:
+		m.prgBank8 = value & 0x1F
+		m.updateOffsets()
+	case 0xD000, 0xD001, 0xD002, 0xD003:
+		m.chrBanks[address&3] = value
+		m.updateOffsets()
+	case 0xE000, 0xE001, 0xE002, 0xE003:
+		m.chrBanks[4+address&3] = value
+		m.updateOffsets()
+	case 0xF000:
+		m.irq.writeLatch(value)
+	case 0xF001:
+		m.irq.writeControl(value)
+	case 0xF002:
+		m.irq.acknowledge()
+	}
+}
+
+func (m *Mapper24) writeMirror(value byte) {
+	switch (value >> 2) & 3 {
+	case 0:
+		m.Cartridge.Mirror = MirrorVertical
+	case 1:
+		m.Cartridge.Mirror = MirrorHorizontal
+	case 2:
+		m.Cartridge.Mirror = MirrorSingle0
+	case 3:
+		m.Cartridge.Mirror = MirrorSingle1
+	}
+}
+
+func (m *Mapper24) prgBankOffset(index int) int {
+	index %= len(m.PRG) / 0x2000
+	offset := index * 0x2000
+	if offset < 0 {
+		offset += len(m.PRG)
+	}
+	return offset
+}
+
+func (m *Mapper24) chrBankOffset(index int, size int) int {
+	return index * size % len(m.CHR)
+}
+
+func (m *Mapper24) updateOffsets() {
+	m.prgOffsets[0] = m.prgBankOffset(int(m.prgBank16) * 2)
+	m.prgOffsets[1] = m.prgBankOffset(int(m.prgBank16)*2 + 1)
+	m.prgOffsets[2] = m.prgBankOffset(int(m.prgBank8))
+	m.prgOffsets[3] = m.prgBankOffset(-1)
+	for i := 0; i < 8; i++ {
+		var bank, size int
+		switch m.bankMode & 3 {
+		case 0:
+			// eight 1KB banks
+			bank, size = int(m.chrBanks[i]), 0x0400
+		case 1:
+			// four 2KB banks
+			bank, size = int(m.chrBanks[i/2]), 0x0800
+		default:
+			// four 1KB banks, then two 2KB banks
+			if i < 4 {
+				bank, size = int(m.chrBanks[i]), 0x0400
+			} else {
+				bank, size = int(m.chrBanks[4+(i-4)/2]), 0x0800
+			}
+		}
+		m.chrOffsets[i] = m.chrBankOffset(bank, size) + i*0x0400%size
+	}
+}
+
+// StepAudio clocks the expansion channels, whose timers run at the full CPU
+// clock rather than every other cycle like the APU pulses
+func (m *Mapper24) StepAudio() {
+	if m.halt {
+		return
+	}
+	m.pulse1.stepTimer(m.shift)
+	m.pulse2.stepTimer(m.shift)
+	m.saw.stepTimer(m.shift)
+}
+
+// AudioOutput scales the channels so that a full volume VRC6 pulse is as
+// loud as a full volume APU pulse
+func (m *Mapper24) AudioOutput() float32 {
+	output := m.pulse1.output() + m.pulse2.output() + m.saw.output()
+	return float32(output) * pulseTable[15] / 15
+}
+
+// VRC6 Pulse
+
+type vrc6Pulse struct {
+	enabled     bool
+	mode        bool // ignore duty, output volume constantly
+	duty        byte
+	volume      byte
+	timerPeriod uint16
+	timerValue  uint16
+	dutyValue   byte
+}
+
+func (p *vrc6Pulse) Save(encoder *gob.Encoder) error {
+	encoder.Encode(p.enabled)
+	encoder.Encode(p.mode)
+	encoder.Encode(p.duty)
+	encoder.Encode(p.volume)
+	encoder.Encode(p.timerPeriod)
+	encoder.Encode(p.timerValue)
+	encoder.Encode(p.dutyValue)
+	return nil
+}
+
+func (p *vrc6Pulse) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&p.enabled)
+	decoder.Decode(&p.mode)
+	decoder.Decode(&p.duty)
+	decoder.Decode(&p.volume)
+	decoder.Decode(&p.timerPeriod)
+	decoder.Decode(&p.timerValue)
+	decoder.Decode(&p.dutyValue)
+	return nil
+}
+
+func (p *vrc6Pulse) writeControl(value byte) {
+	p.mode = value&0x80 == 0x80
+	p.duty = (value >> 4) & 7
+	p.volume = value & 15
+}
+
+func (p *vrc6Pulse) writePeriodLow(value byte) {
+	p.timerPeriod = (p.timerPeriod & 0x0F00) | uint16(value)
+}
+
+func (p *vrc6Pulse) writePeriodHigh(value byte) {
+	p.timerPeriod = (p.timerPeriod & 0x00FF) | (uint16(value&15) << 8)
+	p.enabled = value&0x80 == 0x80
+	if !p.enabled {
+		p.dutyValue = 15
+	}
+}
+
+func (p *vrc6Pulse) stepTimer(shift uint) {
+	if !p.enabled {
+		return
+	}
+	if p.timerValue == 0 {
+		p.timerValue = p.timerPeriod >> shift
+		p.dutyValue = (p.dutyValue + 1) % 16
+	} else {
+		p.timerValue--
+	}
+}
+
+func (p *vrc6Pulse) output() byte {
+	if !p.enabled {
+		return 0
+	}
+	if p.mode || p.dutyValue <= p.duty {
+		return p.volume
+	}
+	return 0
+}
+
+// VRC6 Sawtooth
+
+type vrc6Saw struct {
+	enabled     bool
+	rate        byte
+	timerPeriod uint16
+	timerValue  uint16
+	step        byte
+	accumulator byte
+}
+
+func (s *vrc6Saw) Save(encoder *gob.Encoder) error {
+	encoder.Encode(s.enabled)
+	encoder.Encode(s.rate)
+	encoder.Encode(s.timerPeriod)
+	encoder.Encode(s.timerValue)
+	encoder.Encode(s.step)
+	encoder.Encode(s.accumulator)
+	return nil
+}
+
+func (s *vrc6Saw) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&s.enabled)
+	decoder.Decode(&s.rate)
+	decoder.Decode(&s.timerPeriod)
+	decoder.Decode(&s.timerValue)
+	decoder.Decode(&s.step)
+	decoder.Decode(&s.accumulator)
+	return nil
+}
+
+func (s *vrc6Saw) writeRate(value byte) {
+	s.rate = value & 0x3F
+}
+
+func (s *vrc6Saw) writePeriodLow(value byte) {
+	s.timerPeriod = (s.timerPeriod & 0x0F00) | uint16(value)
+}
+
+func (s *vrc6Saw) writePeriodHigh(value byte) {
+	s.timerPeriod = (s.timerPeriod & 0x00FF) | (uint16(value&15) << 8)
+	s.enabled = value&0x80 == 0x80
+	if !s.enabled {
+		s.step = 0
+		s.accumulator = 0
+	}
+}
+
+// stepTimer adds the rate to the accumulator on every other timer clock and
+// resets it after the seventh addition
+func (s *vrc6Saw) stepTimer(shift uint) {
+	if !s.enabled {
+		return
+	}
+	if s.timerValue == 0 {
+		s.timerValue = s.timerPeriod >> shift
+		s.step = (s.step + 1) % 14
+		if s.step == 0 {
+			s.accumulator = 0
+		} else if s.step%2 == 0 {
+			s.accumulator += s.rate
+		}
+	} else {
+		s.timerValue--
+	}
+}
+
+func (s *vrc6Saw) output() byte {
+	return s.accumulator >> 3
+}
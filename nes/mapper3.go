@@ -5,16 +5,20 @@ import (
 	"log"
 )
 
+// Mapper3 is CNROM. Submapper 2 has bus conflicts, so the bank latched is
+// the AND of the value written and the ROM byte at that address.
 type Mapper3 struct {
 	*Cartridge
-	chrBank  int
-	prgBank1 int
-	prgBank2 int
+	chrBank      int
+	prgBank1     int
+	prgBank2     int
+	busConflicts bool
 }
 
 func NewMapper3(cartridge *Cartridge) Mapper {
 	prgBanks := len(cartridge.PRG) / 0x4000
-	return &Mapper3{cartridge, 0, 0, prgBanks - 1}
+	busConflicts := cartridge.Submapper == 2
+	return &Mapper3{cartridge, 0, 0, prgBanks - 1, busConflicts}
 }
 
 func (m *Mapper3) Save(encoder *gob.Encoder) error {
@@ -31,6 +35,12 @@ func (m *Mapper3) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+func (m *Mapper3) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper3(m.Cartridge).(*Mapper3)
+	}
+}
+
 func (m *Mapper3) Step() {
 }
 
@@ -60,6 +70,9 @@ func (m *Mapper3) Write(address uint16, value byte) {
 		index := m.chrBank*0x2000 + int(address)
 		m.CHR[index] = value
 	case address >= 0x8000:
+		if m.busConflicts {
+			value &= m.Read(address)
+		}
 		m.chrBank = int(value & 3)
 	case address >= 0x6000:
 		index := int(address) - 0x6000
@@ -5,6 +5,21 @@ import (
 	"log"
 )
 
+// MMC3 revisions differ in how the IRQ counter behaves when it is reloaded
+// with zero
+const (
+	// MMC3RevisionB (Sharp MMC3B, MMC3C) fires an IRQ on every clock while
+	// the counter is reloaded with zero
+	MMC3RevisionB = iota
+	// MMC3RevisionA (MMC3A, NEC MMC3B) only fires when the counter reaches
+	// zero by decrementing or by an explicit reload
+	MMC3RevisionA
+)
+
+// a12Filter is the number of PPU cycles A12 must stay low before a rising
+// edge clocks the counter, roughly the three CPU cycles of the real filter
+const a12Filter = 10
+
 type Mapper4 struct {
 	*Cartridge
 	console    *Console
@@ -16,7 +31,15 @@ type Mapper4 struct {
 	chrOffsets [8]int
 	reload     byte
 	counter    byte
+	irqReload  bool
 	irqEnable  bool
+	a12        bool
+	a12Low     int
+	revision   byte
+	ramEnable  bool
+	ramProtect bool
+	mmc6       bool // submapper 1: 1KB of PRG-RAM at $7000 with its own enables
+	mmc6RAM    byte // MMC6 $A001: read and write enables for each 512 bytes
 }
 
 func NewMapper4(console *Console, cartridge *Cartridge) Mapper {
@@ -25,6 +48,16 @@ func NewMapper4(console *Console, cartridge *Cartridge) Mapper {
 	m.prgOffsets[1] = m.prgBankOffset(1)
 	m.prgOffsets[2] = m.prgBankOffset(-2)
 	m.prgOffsets[3] = m.prgBankOffset(-1)
+	// submapper 4 marks boards with the older MMC3A behavior; iNES files
+	// get it from a game database entry
+	if cartridge.Submapper == 4 {
+		m.revision = MMC3RevisionA
+	}
+	// the MMC6 (submapper 1) has its RAM disabled until enabled by $8000;
+	// iNES files can't tell it apart, so the game database marks the MMC6
+	// games
+	m.mmc6 = cartridge.Submapper == 1
+	m.ramEnable = !m.mmc6
 	return &m
 }
 
@@ -37,7 +70,13 @@ func (m *Mapper4) Save(encoder *gob.Encoder) error {
 	encoder.Encode(m.chrOffsets)
 	encoder.Encode(m.reload)
 	encoder.Encode(m.counter)
+	encoder.Encode(m.irqReload)
 	encoder.Encode(m.irqEnable)
+	encoder.Encode(m.a12)
+	encoder.Encode(m.a12Low)
+	encoder.Encode(m.ramEnable)
+	encoder.Encode(m.ramProtect)
+	encoder.Encode(m.mmc6RAM)
 	return nil
 }
 
@@ -50,32 +89,55 @@ func (m *Mapper4) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&m.chrOffsets)
 	decoder.Decode(&m.reload)
 	decoder.Decode(&m.counter)
+	decoder.Decode(&m.irqReload)
 	decoder.Decode(&m.irqEnable)
+	decoder.Decode(&m.a12)
+	decoder.Decode(&m.a12Low)
+	decoder.Decode(&m.ramEnable)
+	decoder.Decode(&m.ramProtect)
+	decoder.Decode(&m.mmc6RAM)
 	return nil
 }
 
-func (m *Mapper4) Step() {
-	ppu := m.console.PPU
-	if ppu.Cycle != 280 { // TODO: this *should* be 260
-		return
+func (m *Mapper4) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper4(m.console, m.Cartridge).(*Mapper4)
 	}
-	if ppu.ScanLine > 239 && ppu.ScanLine < 261 {
-		return
+}
+
+func (m *Mapper4) Step() {
+	if !m.a12 {
+		m.a12Low++
 	}
-	if ppu.flagShowBackground == 0 && ppu.flagShowSprites == 0 {
-		return
+}
+
+// WatchPPUAddress clocks the scanline counter on filtered rising edges of
+// PPU address line A12
+func (m *Mapper4) WatchPPUAddress(address uint16) {
+	a12 := address&0x1000 != 0
+	if a12 {
+		if !m.a12 && m.a12Low >= a12Filter {
+			m.HandleScanLine()
+		}
+		m.a12Low = 0
 	}
-	m.HandleScanLine()
+	m.a12 = a12
 }
 
 func (m *Mapper4) HandleScanLine() {
-	if m.counter == 0 {
+	counter := m.counter
+	if m.counter == 0 || m.irqReload {
 		m.counter = m.reload
 	} else {
 		m.counter--
-		if m.counter == 0 && m.irqEnable {
-			m.console.CPU.triggerIRQ()
-		}
+	}
+	fire := m.counter == 0 && m.irqEnable
+	if m.revision == MMC3RevisionA && counter == 0 && !m.irqReload {
+		fire = false
+	}
+	m.irqReload = false
+	if fire {
+		m.console.CPU.triggerIRQ()
 	}
 }
 
@@ -91,6 +153,12 @@ func (m *Mapper4) Read(address uint16) byte {
 		offset := address % 0x2000
 		return m.PRG[m.prgOffsets[bank]+int(offset)]
 	case address >= 0x6000:
+		if m.mmc6 {
+			return m.readMMC6RAM(address)
+		}
+		if !m.ramEnable {
+			return m.console.openBus
+		}
 		return m.SRAM[int(address)-0x6000]
 	default:
 		log.Fatalf("unhandled mapper4 read at address: 0x%04X", address)
@@ -107,7 +175,11 @@ func (m *Mapper4) Write(address uint16, value byte) {
 	case address >= 0x8000:
 		m.writeRegister(address, value)
 	case address >= 0x6000:
-		m.SRAM[int(address)-0x6000] = value
+		if m.mmc6 {
+			m.writeMMC6RAM(address, value)
+		} else if m.ramEnable && !m.ramProtect {
+			m.SRAM[int(address)-0x6000] = value
+		}
 	default:
 		log.Fatalf("unhandled mapper4 write at address: 0x%04X", address)
 	}
@@ -135,6 +207,13 @@ func (m *Mapper4) writeRegister(address uint16, value byte) {
 }
 
 func (m *Mapper4) writeBankSelect(value byte) {
+	if m.mmc6 {
+		// disabling the RAM also clears the $A001 enables
+		m.ramEnable = value&0x20 != 0
+		if !m.ramEnable {
+			m.mmc6RAM = 0
+		}
+	}
 	m.prgMode = (value >> 6) & 1
 	m.chrMode = (value >> 7) & 1
 	m.register = value & 7
@@ -147,6 +226,10 @@ func (m *Mapper4) writeBankData(value byte) {
 }
 
 func (m *Mapper4) writeMirror(value byte) {
+	// four-screen boards hardwire the nametables
+	if m.Cartridge.Mirror == MirrorFour {
+		return
+	}
 	switch value & 1 {
 	case 0:
 		m.Cartridge.Mirror = MirrorVertical
@@ -155,7 +238,51 @@ func (m *Mapper4) writeMirror(value byte) {
 	}
 }
 
+// writeProtect enables PRG-RAM with bit 7 and denies writes to it with
+// bit 6. The MMC6 instead takes read and write enables for each half of its
+// RAM, and only while the RAM is enabled.
 func (m *Mapper4) writeProtect(value byte) {
+	if m.mmc6 {
+		if m.ramEnable {
+			m.mmc6RAM = value & 0xF0
+		}
+		return
+	}
+	m.ramEnable = value&0x80 != 0
+	m.ramProtect = value&0x40 != 0
+}
+
+// mmc6Enables returns the read and write enable bits of $A001 for the half
+// of the MMC6 RAM an address falls in
+func (m *Mapper4) mmc6Enables(address uint16) (read, write bool) {
+	if address&0x0200 != 0 {
+		return m.mmc6RAM&0x80 != 0, m.mmc6RAM&0x40 != 0
+	}
+	return m.mmc6RAM&0x20 != 0, m.mmc6RAM&0x10 != 0
+}
+
+// readMMC6RAM reads the 1KB of MMC6 RAM, mirrored through $7000-$7FFF.
+// With neither half readable the reads are open bus; a half that isn't
+// readable while the other is reads as zero.
+func (m *Mapper4) readMMC6RAM(address uint16) byte {
+	if address < 0x7000 || !m.ramEnable || m.mmc6RAM&0xA0 == 0 {
+		return m.console.openBus
+	}
+	if read, _ := m.mmc6Enables(address); !read {
+		return 0
+	}
+	return m.SRAM[address&0x03FF]
+}
+
+// writeMMC6RAM writes to a half of the MMC6 RAM that is enabled for both
+// reading and writing
+func (m *Mapper4) writeMMC6RAM(address uint16, value byte) {
+	if address < 0x7000 || !m.ramEnable {
+		return
+	}
+	if read, write := m.mmc6Enables(address); read && write {
+		m.SRAM[address&0x03FF] = value
+	}
 }
 
 func (m *Mapper4) writeIRQLatch(value byte) {
@@ -164,6 +291,7 @@ func (m *Mapper4) writeIRQLatch(value byte) {
 
 func (m *Mapper4) writeIRQReload(value byte) {
 	m.counter = 0
+	m.irqReload = true
 }
 
 func (m *Mapper4) writeIRQDisable(value byte) {
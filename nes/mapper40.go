@@ -29,6 +29,12 @@ func (m *Mapper40) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+func (m *Mapper40) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper40(m.console, m.Cartridge).(*Mapper40)
+	}
+}
+
 func (m *Mapper40) Step() {
 	if m.cycles < 0 {
 		return
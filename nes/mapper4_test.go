@@ -0,0 +1,30 @@
+package nes
+
+import (
+	"hash/crc32"
+	"testing"
+)
+
+// TestMMC3Revision checks that a game database entry for submapper 4
+// selects the MMC3A counter behavior for an iNES file, and that it survives
+// a power cycle
+func TestMMC3Revision(t *testing.T) {
+	prg := testProgram(0xEA)
+	chr := make([]byte, 0x2000)
+	crc := crc32.Update(crc32.ChecksumIEEE(prg), crc32.IEEETable, chr)
+	RegisterGame(crc, GameInfo{Mapper: 4, Submapper: 4, PRGRAMSize: 0x2000})
+	defer delete(gameDatabase, crc)
+
+	console := newTestConsole(t, prg)
+	m, ok := console.Mapper.(*Mapper4)
+	if !ok {
+		t.Fatalf("mapper is %T, want *Mapper4", console.Mapper)
+	}
+	if m.revision != MMC3RevisionA {
+		t.Fatal("submapper 4 did not select MMC3RevisionA")
+	}
+	console.PowerCycle()
+	if m := console.Mapper.(*Mapper4); m.revision != MMC3RevisionA {
+		t.Error("power cycle lost MMC3RevisionA")
+	}
+}
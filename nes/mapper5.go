@@ -0,0 +1,575 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// MMC5 ExRAM modes ($5104)
+const (
+	exRAMNameTable  = iota // extra nametable
+	exRAMAttributes        // extended attributes
+	exRAMReadWrite         // CPU read/write RAM
+	exRAMReadOnly          // CPU read-only RAM
+)
+
+type Mapper5 struct {
+	*Cartridge
+	console       *Console
+	prgMode       byte
+	chrMode       byte
+	prgRAMProtect [2]byte
+	exRAMMode     byte
+	nameTableMode byte
+	fillTile      byte
+	fillAttribute byte
+	prgRAMBank    byte
+	prgBanks      [4]byte
+	chrBanks      [12]int
+	chrUpper      byte
+	chrSetB       bool // $5128-$512B were written after $5120-$5127
+	prgOffsets    [5]int
+	prgRAM        [5]bool
+	chrOffsetsA   [8]int
+	chrOffsetsB   [8]int
+	splitControl  byte
+	splitScroll   byte
+	splitBank     byte
+	splitTile     bool // the background tile being fetched is in the split
+	splitY        int
+	irqCompare    byte
+	irqEnable     bool
+	irqPending    bool
+	inFrame       bool
+	scanLine      int
+	multiplicand  byte
+	multiplier    byte
+	exRAM         [1024]byte
+	exAttribute   byte // ExRAM byte of the tile being fetched
+	pulse1        Pulse
+	pulse2        Pulse
+	pcmReadMode   bool
+	pcmIRQEnable  bool
+	pcmIRQ        bool
+	pcm           byte
+	audioCycle    uint64
+}
+
+func NewMapper5(console *Console, cartridge *Cartridge) Mapper {
+	// MMC5 boards carry up to 64KB of PRG-RAM; iNES headers can't say how
+	// much, so give them all of it
+	if !cartridge.sizesKnown && len(cartridge.SRAM) < 0x10000 {
+		cartridge.SRAM = make([]byte, 0x10000)
+	}
+	m := Mapper5{Cartridge: cartridge, console: console}
+	m.prgMode = 3
+	m.chrMode = 3
+	m.prgBanks = [4]byte{0xFF, 0xFF, 0xFF, 0xFF}
+	m.updatePRGOffsets()
+	m.updateCHROffsets()
+	return &m
+}
+
+func (m *Mapper5) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.prgMode)
+	encoder.Encode(m.chrMode)
+	encoder.Encode(m.prgRAMProtect)
+	encoder.Encode(m.exRAMMode)
+	encoder.Encode(m.nameTableMode)
+	encoder.Encode(m.fillTile)
+	encoder.Encode(m.fillAttribute)
+	encoder.Encode(m.prgRAMBank)
+	encoder.Encode(m.prgBanks)
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.chrUpper)
+	encoder.Encode(m.chrSetB)
+	encoder.Encode(m.prgOffsets)
+	encoder.Encode(m.prgRAM)
+	encoder.Encode(m.chrOffsetsA)
+	encoder.Encode(m.chrOffsetsB)
+	encoder.Encode(m.splitControl)
+	encoder.Encode(m.splitScroll)
+	encoder.Encode(m.splitBank)
+	encoder.Encode(m.splitTile)
+	encoder.Encode(m.splitY)
+	encoder.Encode(m.irqCompare)
+	encoder.Encode(m.irqEnable)
+	encoder.Encode(m.irqPending)
+	encoder.Encode(m.inFrame)
+	encoder.Encode(m.scanLine)
+	encoder.Encode(m.multiplicand)
+	encoder.Encode(m.multiplier)
+	encoder.Encode(m.exRAM)
+	encoder.Encode(m.exAttribute)
+	m.pulse1.Save(encoder)
+	m.pulse2.Save(encoder)
+	encoder.Encode(m.pcmReadMode)
+	encoder.Encode(m.pcmIRQEnable)
+	encoder.Encode(m.pcmIRQ)
+	encoder.Encode(m.pcm)
+	encoder.Encode(m.audioCycle)
+	return nil
+}
+
+func (m *Mapper5) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.prgMode)
+	decoder.Decode(&m.chrMode)
+	decoder.Decode(&m.prgRAMProtect)
+	decoder.Decode(&m.exRAMMode)
+	decoder.Decode(&m.nameTableMode)
+	decoder.Decode(&m.fillTile)
+	decoder.Decode(&m.fillAttribute)
+	decoder.Decode(&m.prgRAMBank)
+	decoder.Decode(&m.prgBanks)
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.chrUpper)
+	decoder.Decode(&m.chrSetB)
+	decoder.Decode(&m.prgOffsets)
+	decoder.Decode(&m.prgRAM)
+	decoder.Decode(&m.chrOffsetsA)
+	decoder.Decode(&m.chrOffsetsB)
+	decoder.Decode(&m.splitControl)
+	decoder.Decode(&m.splitScroll)
+	decoder.Decode(&m.splitBank)
+	decoder.Decode(&m.splitTile)
+	decoder.Decode(&m.splitY)
+	decoder.Decode(&m.irqCompare)
+	decoder.Decode(&m.irqEnable)
+	decoder.Decode(&m.irqPending)
+	decoder.Decode(&m.inFrame)
+	decoder.Decode(&m.scanLine)
+	decoder.Decode(&m.multiplicand)
+	decoder.Decode(&m.multiplier)
+	decoder.Decode(&m.exRAM)
+	decoder.Decode(&m.exAttribute)
+	m.pulse1.Load(decoder)
+	m.pulse2.Load(decoder)
+	decoder.Decode(&m.pcmReadMode)
+	decoder.Decode(&m.pcmIRQEnable)
+	decoder.Decode(&m.pcmIRQ)
+	decoder.Decode(&m.pcm)
+	decoder.Decode(&m.audioCycle)
+	return nil
+}
+
+func (m *Mapper5) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper5(m.console, m.Cartridge).(*Mapper5)
+	}
+}
+
+// Step follows the PPU to drive the scanline IRQ. The real chip watches for
+// the repeated nametable fetches at the end of each line; here the PPU
+// position is read directly.
+func (m *Mapper5) Step() {
+	ppu := m.console.PPU
+	rendering := ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0
+	preLine := m.console.timing.scanLines - 1
+	if !rendering || (ppu.ScanLine >= 240 && ppu.ScanLine < preLine) {
+		m.inFrame = false
+	} else if ppu.Cycle == 337 && (ppu.ScanLine == preLine || ppu.ScanLine < 239) {
+		if !m.inFrame {
+			m.inFrame = true
+			m.irqPending = false
+			m.scanLine = 0
+		} else {
+			m.scanLine++
+			if m.scanLine == int(m.irqCompare) {
+				m.irqPending = true
+			}
+		}
+	}
+	if m.irqPending && m.irqEnable || m.pcmIRQ && m.pcmIRQEnable {
+		m.console.CPU.triggerIRQ()
+	}
+}
+
+func (m *Mapper5) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrIndex(address)]
+	case address >= 0x6000:
+		slot := (address - 0x6000) / 0x2000
+		index := m.prgOffsets[slot] + int(address%0x2000)
+		var value byte
+		if m.prgRAM[slot] {
+			value = m.SRAM[index]
+		} else {
+			value = m.PRG[index]
+		}
+		if m.pcmReadMode && address >= 0x8000 && address < 0xC000 {
+			m.writePCM(value)
+		}
+		return value
+	default:
+		log.Fatalf("unhandled mapper5 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper5) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		m.CHR[m.chrIndex(address)] = value
+	case address >= 0x6000:
+		slot := (address - 0x6000) / 0x2000
+		if m.prgRAM[slot] && m.prgRAMProtect == [2]byte{2, 1} {
+			m.SRAM[m.prgOffsets[slot]+int(address%0x2000)] = value
+		}
+		if m.pcmReadMode && address >= 0x8000 && address < 0xC000 {
+			m.writePCM(value)
+		}
+	default:
+		log.Fatalf("unhandled mapper5 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper5) ReadExpansion(address uint16) byte {
+	switch {
+	case address == 0x5010:
+		var value byte
+		if m.pcmIRQ {
+			value |= 0x80
+		}
+		if m.pcmReadMode {
+			value |= 0x01
+		}
+		m.pcmIRQ = false
+		return value
+	case address == 0x5015:
+		var value byte
+		if m.pulse1.lengthValue > 0 {
+			value |= 1
+		}
+		if m.pulse2.lengthValue > 0 {
+			value |= 2
+		}
+		return value
+	case address == 0x5204:
+		var value byte
+		if m.irqPending {
+			value |= 0x80
+		}
+		if m.inFrame {
+			value |= 0x40
+		}
+		m.irqPending = false
+		return value
+	case address == 0x5205:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier))
+	case address == 0x5206:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier) >> 8)
+	case address >= 0x5C00:
+		if m.exRAMMode >= exRAMReadWrite {
+			return m.exRAM[address-0x5C00]
+		}
+	}
+	return m.console.openBus
+}
+
+func (m *Mapper5) WriteExpansion(address uint16, value byte) {
+	switch {
+	case address == 0x5000:
+		m.pulse1.writeControl(value)
+	case address == 0x5002:
+		m.pulse1.writeTimerLow(value)
+	case address == 0x5003:
+		m.pulse1.writeTimerHigh(value)
+	case address == 0x5004:
+		m.pulse2.writeControl(value)
+	case address == 0x5006:
+		m.pulse2.writeTimerLow(value)
+	case address == 0x5007:
+		m.pulse2.writeTimerHigh(value)
+	case address == 0x5010:
+		m.pcmReadMode = value&0x01 != 0
+		m.pcmIRQEnable = value&0x80 != 0
+	case address == 0x5011:
+		if !m.pcmReadMode {
+			m.writePCM(value)
+		}
+	case address == 0x5015:
+		m.pulse1.enabled = value&1 == 1
+		m.pulse2.enabled = value&2 == 2
+		if !m.pulse1.enabled {
+			m.pulse1.lengthValue = 0
+		}
+		if !m.pulse2.enabled {
+			m.pulse2.lengthValue = 0
+		}
+	case address == 0x5100:
+		m.prgMode = value & 3
+		m.updatePRGOffsets()
+	case address == 0x5101:
+		m.chrMode = value & 3
+		m.updateCHROffsets()
+	case address == 0x5102 || address == 0x5103:
+		m.prgRAMProtect[address-0x5102] = value & 3
+	case address == 0x5104:
+		m.exRAMMode = value & 3
+	case address == 0x5105:
+		m.nameTableMode = value
+	case address == 0x5106:
+		m.fillTile = value
+	case address == 0x5107:
+		m.fillAttribute = value & 3
+	case address == 0x5113:
+		m.prgRAMBank = value
+		m.updatePRGOffsets()
+	case address >= 0x5114 && address <= 0x5117:
+		m.prgBanks[address-0x5114] = value
+		m.updatePRGOffsets()
+	case address >= 0x5120 && address <= 0x512B:
+		m.chrBanks[address-0x5120] = int(value) | int(m.chrUpper&3)<<8
+		m.chrSetB = address >= 0x5128
+		m.updateCHROffsets()
+	case address == 0x5130:
+		m.chrUpper = value & 3
+	case address == 0x5200:
+		m.splitControl = value
+	case address == 0x5201:
+		m.splitScroll = value
+	case address == 0x5202:
+		m.splitBank = value
+	case address == 0x5203:
+		m.irqCompare = value
+	case address == 0x5204:
+		m.irqEnable = value&0x80 != 0
+	case address == 0x5205:
+		m.multiplicand = value
+	case address == 0x5206:
+		m.multiplier = value
+	case address >= 0x5C00:
+		switch m.exRAMMode {
+		case exRAMNameTable, exRAMAttributes:
+			// the PPU owns ExRAM while it is used for rendering, so
+			// writes outside of a frame store zero
+			if !m.inFrame {
+				value = 0
+			}
+			m.exRAM[address-0x5C00] = value
+		case exRAMReadWrite:
+			m.exRAM[address-0x5C00] = value
+		}
+	}
+}
+
+func (m *Mapper5) ReadNameTable(address uint16) byte {
+	ppu := m.console.PPU
+	offset := (address - 0x2000) % 0x0400
+	switch ppu.access {
+	case ppuAccessNameTable:
+		m.splitTile = m.inSplit()
+		if m.splitTile {
+			return m.exRAM[m.splitY/8*32+m.tileIndex()%32]
+		}
+		m.exAttribute = m.exRAM[offset]
+	case ppuAccessAttribute:
+		if m.splitTile {
+			x := m.tileIndex() % 32
+			value := m.exRAM[0x3C0+m.splitY/32*8+x/4]
+			shift := uint(m.splitY/16%2*4 + x/2%2*2)
+			return (value >> shift & 3) * 0x55
+		}
+		if m.exRAMMode == exRAMAttributes {
+			return (m.exAttribute >> 6) * 0x55
+		}
+	}
+	switch m.nameTableSource(address) {
+	case 0:
+		return ppu.nameTableData[offset]
+	case 1:
+		return ppu.nameTableData[0x400+offset]
+	case 2:
+		if m.exRAMMode <= exRAMAttributes {
+			return m.exRAM[offset]
+		}
+		return 0
+	default:
+		if offset >= 0x3C0 {
+			return m.fillAttribute * 0x55
+		}
+		return m.fillTile
+	}
+}
+
+func (m *Mapper5) WriteNameTable(address uint16, value byte) {
+	ppu := m.console.PPU
+	offset := (address - 0x2000) % 0x0400
+	switch m.nameTableSource(address) {
+	case 0:
+		ppu.nameTableData[offset] = value
+	case 1:
+		ppu.nameTableData[0x400+offset] = value
+	case 2:
+		if m.exRAMMode <= exRAMAttributes {
+			m.exRAM[offset] = value
+		}
+	}
+}
+
+// nameTableSource returns what backs the nametable containing an address:
+// 0 and 1 are the console's nametable pages, 2 is ExRAM and 3 is fill mode
+func (m *Mapper5) nameTableSource(address uint16) byte {
+	table := (address - 0x2000) % 0x1000 / 0x0400
+	return (m.nameTableMode >> (table * 2)) & 3
+}
+
+// tileIndex returns the horizontal tile position of the current background
+// fetch. The first two tiles of a line are fetched at the end of the line
+// before.
+func (m *Mapper5) tileIndex() int {
+	cycle := m.console.PPU.Cycle
+	if cycle >= 321 {
+		return (cycle - 321) / 8
+	}
+	return (cycle-1)/8 + 2
+}
+
+// inSplit reports whether the tile being fetched falls in the vertical split
+// region, updating the split row as a side effect
+func (m *Mapper5) inSplit() bool {
+	if m.splitControl&0x80 == 0 || m.exRAMMode > exRAMAttributes {
+		return false
+	}
+	ppu := m.console.PPU
+	line := ppu.ScanLine
+	if ppu.Cycle >= 321 {
+		line++
+	}
+	if line >= m.console.timing.scanLines {
+		line = 0
+	}
+	m.splitY = (int(m.splitScroll) + line) % 240
+	tile := m.tileIndex()
+	threshold := int(m.splitControl & 0x1F)
+	if m.splitControl&0x40 == 0 {
+		return tile < threshold
+	}
+	return tile >= threshold
+}
+
+func (m *Mapper5) chrIndex(address uint16) int {
+	ppu := m.console.PPU
+	bigSprites := ppu.flagSpriteSize == 1 &&
+		(ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0)
+	useB := m.chrSetB
+	switch ppu.access {
+	case ppuAccessBackground:
+		if m.splitTile {
+			bank := int(m.splitBank) * 0x1000
+			offset := int(address&0x0FF8) + m.splitY%8
+			return (bank + offset) % len(m.CHR)
+		}
+		if m.exRAMMode == exRAMAttributes {
+			bank := int(m.exAttribute&0x3F) | int(m.chrUpper)<<6
+			return (bank*0x1000 + int(address&0x0FFF)) % len(m.CHR)
+		}
+		if bigSprites {
+			useB = true
+		}
+	case ppuAccessSprite:
+		if bigSprites {
+			useB = false
+		}
+	}
+	bank := address / 0x0400
+	offset := int(address % 0x0400)
+	if useB {
+		return m.chrOffsetsB[bank] + offset
+	}
+	return m.chrOffsetsA[bank] + offset
+}
+
+func (m *Mapper5) updatePRGOffsets() {
+	m.setPRGSlot(0, m.prgRAMBank&0x7F)
+	banks := m.prgBanks
+	switch m.prgMode {
+	case 0:
+		bank := banks[3] & 0xFC
+		for i := byte(0); i < 4; i++ {
+			m.setPRGSlot(int(i)+1, bank|i|0x80)
+		}
+	case 1:
+		bank := banks[1] & 0xFE
+		m.setPRGSlot(1, bank)
+		m.setPRGSlot(2, bank|1)
+		bank = banks[3] & 0xFE
+		m.setPRGSlot(3, bank|0x80)
+		m.setPRGSlot(4, bank|0x81)
+	case 2:
+		bank := banks[1] & 0xFE
+		m.setPRGSlot(1, bank)
+		m.setPRGSlot(2, bank|1)
+		m.setPRGSlot(3, banks[2])
+		m.setPRGSlot(4, banks[3]|0x80)
+	case 3:
+		m.setPRGSlot(1, banks[0])
+		m.setPRGSlot(2, banks[1])
+		m.setPRGSlot(3, banks[2])
+		m.setPRGSlot(4, banks[3]|0x80)
+	}
+}
+
+// setPRGSlot maps an 8KB bank into a slot of $6000-$FFFF. Bit 7 of the bank
+// number selects ROM, otherwise the low bits select a PRG-RAM bank.
+func (m *Mapper5) setPRGSlot(slot int, bank byte) {
+	if bank&0x80 != 0 {
+		m.prgRAM[slot] = false
+		m.prgOffsets[slot] = int(bank&0x7F) * 0x2000 % len(m.PRG)
+	} else {
+		m.prgRAM[slot] = true
+		// ETROM boards have two 8KB chips, selected by bit 2
+		if len(m.SRAM) == 0x4000 {
+			bank >>= 2
+		}
+		m.prgOffsets[slot] = int(bank&0x07) * 0x2000 % len(m.SRAM)
+	}
+}
+
+func (m *Mapper5) updateCHROffsets() {
+	size := 0x2000 >> m.chrMode
+	count := 8 >> m.chrMode
+	for i := 0; i < 8; i++ {
+		register := i | (count - 1)
+		offset := (i % count) * 0x0400
+		bankA := m.chrBanks[register]
+		bankB := m.chrBanks[8+register%4]
+		m.chrOffsetsA[i] = (bankA*size + offset) % len(m.CHR)
+		m.chrOffsetsB[i] = (bankB*size + offset) % len(m.CHR)
+	}
+}
+
+func (m *Mapper5) writePCM(value byte) {
+	if value == 0 {
+		m.pcmIRQ = true
+		return
+	}
+	m.pcm = value
+}
+
+// StepAudio clocks the pulse timers every other CPU cycle like the APU, but
+// the envelopes and length counters run from a fixed 240 Hz clock instead of
+// the APU frame counter
+func (m *Mapper5) StepAudio() {
+	m.audioCycle++
+	if m.audioCycle%2 == 0 {
+		m.pulse1.stepTimer()
+		m.pulse2.stepTimer()
+	}
+	rate := m.console.timing.cpuFrequency / 240
+	f1 := int(float64(m.audioCycle-1) / rate)
+	f2 := int(float64(m.audioCycle) / rate)
+	if f1 != f2 {
+		m.pulse1.stepEnvelope()
+		m.pulse1.stepLength()
+		m.pulse2.stepEnvelope()
+		m.pulse2.stepLength()
+	}
+}
+
+func (m *Mapper5) AudioOutput() float32 {
+	p1 := m.pulse1.output()
+	p2 := m.pulse2.output()
+	return pulseTable[p1+p2] + tndTable[m.pcm>>1]
+}
@@ -0,0 +1,394 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+	"math"
+)
+
+// Mapper69 is the Sunsoft FME-7, and the Sunsoft 5B which adds a YM2149F
+// based sound generator
+type Mapper69 struct {
+	*Cartridge
+	console    *Console
+	command    byte
+	prgBanks   [4]byte // $6000, $8000, $A000, $C000
+	chrBanks   [8]byte
+	prgOffsets [5]int
+	chrOffsets [8]int
+	irqEnable  bool
+	irqCount   bool
+	irqCounter uint16
+	irqPending bool
+	audio      sunsoft5B
+}
+
+func NewMapper69(console *Console, cartridge *Cartridge) Mapper {
+	m := Mapper69{Cartridge: cartridge, console: console}
+	m.updateOffsets()
+	return &m
+}
+
+func (m *Mapper69) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.command)
+	encoder.Encode(m.prgBanks)
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.prgOffsets)
+	encoder.Encode(m.chrOffsets)
+	encoder.Encode(m.irqEnable)
+	encoder.Encode(m.irqCount)
+	encoder.Encode(m.irqCounter)
+	encoder.Encode(m.irqPending)
+	m.audio.Save(encoder)
+	return nil
+}
+
+func (m *Mapper69) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.command)
+	decoder.Decode(&m.prgBanks)
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.prgOffsets)
+	decoder.Decode(&m.chrOffsets)
+	decoder.Decode(&m.irqEnable)
+	decoder.Decode(&m.irqCount)
+	decoder.Decode(&m.irqCounter)
+	decoder.Decode(&m.irqPending)
+	m.audio.Load(decoder)
+	return nil
+}
+
+func (m *Mapper69) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper69(m.console, m.Cartridge).(*Mapper69)
+	}
+}
+
+func (m *Mapper69) Step() {
+}
+
+// WatchCPUCycle clocks the IRQ counter, which decrements every cycle and
+// fires when it wraps from $0000 to $FFFF
+func (m *Mapper69) WatchCPUCycle() {
+	if m.irqCount {
+		m.irqCounter--
+		if m.irqCounter == 0xFFFF && m.irqEnable {
+			m.irqPending = true
+		}
+	}
+	if m.irqPending {
+		m.console.CPU.triggerIRQ()
+	}
+}
+
+func (m *Mapper69) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		return m.CHR[m.chrOffsets[bank]+int(offset)]
+	case address >= 0x8000:
+		address = address - 0x8000
+		bank := address / 0x2000
+		offset := address % 0x2000
+		return m.PRG[m.prgOffsets[bank+1]+int(offset)]
+	case address >= 0x6000:
+		offset := int(address) - 0x6000
+		switch m.prgBanks[0] & 0xC0 {
+		case 0xC0:
+			return m.SRAM[m.prgOffsets[0]+offset]
+		case 0x40:
+			// RAM selected but disabled
+			return m.console.openBus
+		default:
+			return m.PRG[m.prgOffsets[0]+offset]
+		}
+	default:
+		log.Fatalf("unhandled mapper69 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper69) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		m.CHR[m.chrOffsets[bank]+int(offset)] = value
+	case address >= 0xE000:
+		m.audio.writeData(value)
+	case address >= 0xC000:
+		m.audio.writeAddress(value)
+	case address >= 0xA000:
+		m.writeParameter(value)
+	case address >= 0x8000:
+		m.command = value & 0x0F
+	case address >= 0x6000:
+		if m.prgBanks[0]&0xC0 == 0xC0 {
+			m.SRAM[m.prgOffsets[0]+int(address)-0x6000] = value
+		}
+	default:
+		log.Fatalf("unhandled mapper69 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper69) writeParameter(value byte) {
+	switch command := m.command; {
+	case command <= 7:
+		m.chrBanks[command] = value
+		m.updateOffsets()
+	case command <= 0xB:
+		m.prgBanks[command-8] = value
+		m.updateOffsets()
+	case command == 0xC:
+		m.writeMirror(value)
+	case command == 0xD:
+		m.irqEnable = value&1 != 0
+		m.irqCount = value&0x80 != 0
+		m.irqPending = false
+	case command == 0xE:
+		m.irqCounter = m.irqCounter&0xFF00 | uint16(value)
+	case command == 0xF:
+		m.irqCounter = m.irqCounter&0x00FF | uint16(value)<<8
+	}
+}
+
+func (m *Mapper69) writeMirror(value byte) {
+	switch value & 3 {
+	case 0:
+		m.Cartridge.Mirror = MirrorVertical
+	case 1:
+		m.Cartridge.Mirror = MirrorHorizontal
+	case 2:
+		m.Cartridge.Mirror = MirrorSingle0
+	case 3:
+		m.Cartridge.Mirror = MirrorSingle1
+	}
+}
+
+func (m *Mapper69) prgBankOffset(index int) int {
+	index %= len(m.PRG) / 0x2000
+	offset := index * 0x2000
+	if offset < 0 {
+		offset += len(m.PRG)
+	}
+	return offset
+}
+
+func (m *Mapper69) chrBankOffset(index int) int {
+	index %= len(m.CHR) / 0x0400
+	return index * 0x0400
+}
+
+func (m *Mapper69) updateOffsets() {
+	bank := int(m.prgBanks[0] & 0x3F)
+	if m.prgBanks[0]&0x40 != 0 {
+		m.prgOffsets[0] = bank * 0x2000 % len(m.SRAM)
+	} else {
+		m.prgOffsets[0] = m.prgBankOffset(bank)
+	}
+	for i := 1; i < 4; i++ {
+		m.prgOffsets[i] = m.prgBankOffset(int(m.prgBanks[i] & 0x3F))
+	}
+	m.prgOffsets[4] = m.prgBankOffset(-1)
+	for i, bank := range m.chrBanks {
+		m.chrOffsets[i] = m.chrBankOffset(int(bank))
+	}
+}
+
+func (m *Mapper69) StepAudio() {
+	m.audio.step()
+}
+
+func (m *Mapper69) AudioOutput() float32 {
+	return m.audio.output()
+}
+
+// Sunsoft 5B
+
+// sunsoft5BVolume is the relative output level of each 4-bit volume step;
+// the chip's DAC is logarithmic, 3 dB per step
+var sunsoft5BVolume [16]float32
+
+func init() {
+	for i := 1; i < 16; i++ {
+		sunsoft5BVolume[i] = float32(math.Pow(10, -3*float64(15-i)/20))
+	}
+}
+
+// sunsoft5B is the sound generator of the Sunsoft 5B: three square wave
+// channels that can each mix in a shared noise source and take their
+// volume from a shared envelope
+type sunsoft5B struct {
+	address   byte
+	registers [16]byte
+	prescaler int
+	tones     [3]sunsoft5BTone
+	noise     uint32 // 17-bit LFSR
+	noiseTime int
+	envelope  sunsoft5BEnvelope
+}
+
+type sunsoft5BTone struct {
+	time   int
+	output bool
+}
+
+type sunsoft5BEnvelope struct {
+	time      int
+	step      byte
+	attack    bool
+	holding   bool
+	holdLevel byte
+}
+
+func (s *sunsoft5B) Save(encoder *gob.Encoder) error {
+	encoder.Encode(s.address)
+	encoder.Encode(s.registers)
+	encoder.Encode(s.prescaler)
+	for _, tone := range s.tones {
+		encoder.Encode(tone.time)
+		encoder.Encode(tone.output)
+	}
+	encoder.Encode(s.noise)
+	encoder.Encode(s.noiseTime)
+	encoder.Encode(s.envelope.time)
+	encoder.Encode(s.envelope.step)
+	encoder.Encode(s.envelope.attack)
+	encoder.Encode(s.envelope.holding)
+	encoder.Encode(s.envelope.holdLevel)
+	return nil
+}
+
+func (s *sunsoft5B) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&s.address)
+	decoder.Decode(&s.registers)
+	decoder.Decode(&s.prescaler)
+	for i := range s.tones {
+		decoder.Decode(&s.tones[i].time)
+		decoder.Decode(&s.tones[i].output)
+	}
+	decoder.Decode(&s.noise)
+	decoder.Decode(&s.noiseTime)
+	decoder.Decode(&s.envelope.time)
+	decoder.Decode(&s.envelope.step)
+	decoder.Decode(&s.envelope.attack)
+	decoder.Decode(&s.envelope.holding)
+	decoder.Decode(&s.envelope.holdLevel)
+	return nil
+}
+
+func (s *sunsoft5B) writeAddress(value byte) {
+	s.address = value
+}
+
+func (s *sunsoft5B) writeData(value byte) {
+	// the upper address bits must be clear for the chip to respond
+	if s.address&0xF0 != 0 {
+		return
+	}
+	s.registers[s.address] = value
+	if s.address == 13 {
+		// writing the shape restarts the envelope
+		s.envelope = sunsoft5BEnvelope{attack: value&4 != 0}
+	}
+}
+
+// step is called once per CPU cycle; the tone, noise and envelope
+// generators all count in units of 16 cycles
+func (s *sunsoft5B) step() {
+	s.prescaler++
+	if s.prescaler < 16 {
+		return
+	}
+	s.prescaler = 0
+	for i := range s.tones {
+		tone := &s.tones[i]
+		period := int(s.registers[i*2]) | int(s.registers[i*2+1]&15)<<8
+		tone.time++
+		if tone.time >= period {
+			tone.time = 0
+			tone.output = !tone.output
+		}
+	}
+	// the noise generator runs at half the rate of the tones
+	s.noiseTime++
+	if s.noiseTime >= int(s.registers[6]&0x1F)*2 {
+		s.noiseTime = 0
+		if s.noise == 0 {
+			s.noise = 1
+		}
+		bit := (s.noise ^ s.noise>>3) & 1
+		s.noise = s.noise>>1 | bit<<16
+	}
+	s.stepEnvelope()
+}
+
+func (s *sunsoft5B) stepEnvelope() {
+	e := &s.envelope
+	if e.holding {
+		return
+	}
+	period := int(s.registers[11]) | int(s.registers[12])<<8
+	e.time++
+	if e.time < period {
+		return
+	}
+	e.time = 0
+	e.step++
+	if e.step < 16 {
+		return
+	}
+	shape := s.registers[13]
+	continues := shape&8 != 0
+	attack := shape&4 != 0
+	alternate := shape&2 != 0
+	hold := shape&1 != 0
+	switch {
+	case !continues:
+		e.holding = true
+		e.holdLevel = 0
+	case hold:
+		e.holding = true
+		if attack != alternate {
+			e.holdLevel = 15
+		}
+	default:
+		e.step = 0
+		if alternate {
+			e.attack = !e.attack
+		}
+	}
+}
+
+func (s *sunsoft5B) envelopeLevel() byte {
+	e := &s.envelope
+	switch {
+	case e.holding:
+		return e.holdLevel
+	case e.attack:
+		return e.step
+	default:
+		return 15 - e.step
+	}
+}
+
+func (s *sunsoft5B) output() float32 {
+	var output float32
+	mixer := s.registers[7]
+	noise := s.noise&1 != 0
+	for i, tone := range s.tones {
+		toneOn := tone.output || mixer&(1<<uint(i)) != 0
+		noiseOn := noise || mixer&(8<<uint(i)) != 0
+		if !toneOn || !noiseOn {
+			continue
+		}
+		volume := s.registers[8+i]
+		if volume&0x10 != 0 {
+			output += sunsoft5BVolume[s.envelopeLevel()]
+		} else {
+			output += sunsoft5BVolume[volume&15]
+		}
+	}
+	// a full volume channel is as loud as a full volume APU pulse
+	return output * pulseTable[15]
+}
@@ -5,13 +5,19 @@ import (
 	"log"
 )
 
+// Mapper7 is AxROM. Submapper 1 is ANROM and AN1ROM, which prevent bus
+// conflicts; submapper 2 is AMROM, where the value latched is the AND of the
+// value written and the ROM byte at that address. AOROM boards come both
+// ways and are left unspecified, without bus conflicts.
 type Mapper7 struct {
 	*Cartridge
-	prgBank int
+	prgBank      int
+	busConflicts bool
 }
 
 func NewMapper7(cartridge *Cartridge) Mapper {
-	return &Mapper7{cartridge, 0}
+	busConflicts := cartridge.Submapper == 2
+	return &Mapper7{cartridge, 0, busConflicts}
 }
 
 func (m *Mapper7) Save(encoder *gob.Encoder) error {
@@ -24,6 +30,12 @@ func (m *Mapper7) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+func (m *Mapper7) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper7(m.Cartridge).(*Mapper7)
+	}
+}
+
 func (m *Mapper7) Step() {
 }
 
@@ -48,6 +60,9 @@ func (m *Mapper7) Write(address uint16, value byte) {
 	case address < 0x2000:
 		m.CHR[address] = value
 	case address >= 0x8000:
+		if m.busConflicts {
+			value &= m.Read(address)
+		}
 		m.prgBank = int(value & 7)
 		switch value & 0x10 {
 		case 0x00:
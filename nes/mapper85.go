@@ -0,0 +1,215 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// Mapper85 is the Konami VRC7. Boards differ in which address line selects
+// the second register of each pair: A4 on VRC7a (submapper 2, Lagrange
+// Point) and A3 on VRC7b (submapper 1, Tiny Toon Adventures 2).
+type Mapper85 struct {
+	*Cartridge
+	console    *Console
+	selectLine uint16
+	prgBanks   [3]byte
+	chrBanks   [8]byte
+	control    byte
+	prgOffsets [4]int
+	chrOffsets [8]int
+	irq        vrcIRQ
+	audio      opll
+}
+
+func NewMapper85(console *Console, cartridge *Cartridge) Mapper {
+	m := Mapper85{Cartridge: cartridge, console: console}
+	switch cartridge.Submapper {
+	case 1:
+		m.selectLine = 0x08
+	case 2:
+		m.selectLine = 0x10
+	default:
+		m.selectLine = 0x18
+	}
+	m.updateOffsets()
+	return &m
+}
+
+func (m *Mapper85) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.prgBanks)
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.control)
+	encoder.Encode(m.prgOffsets)
+	encoder.Encode(m.chrOffsets)
+	m.irq.Save(encoder)
+	m.audio.Save(encoder)
+	return nil
+}
+
+func (m *Mapper85) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.prgBanks)
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.control)
+	decoder.Decode(&m.prgOffsets)
+	decoder.Decode(&m.chrOffsets)
+	m.irq.Load(decoder)
+	m.audio.Load(decoder)
+	return nil
+}
+
+func (m *Mapper85) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper85(m.console, m.Cartridge).(*Mapper85)
+	}
+}
+
+func (m *Mapper85) Step() {
+}
+
+func (m *Mapper85) WatchCPUCycle() {
+	m.irq.step(m.console)
+}
+
+func (m *Mapper85) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		return m.CHR[m.chrOffsets[bank]+int(offset)]
+	case address >= 0x8000:
+		address = address - 0x8000
+		bank := address / 0x2000
+		offset := address % 0x2000
+		return m.PRG[m.prgOffsets[bank]+int(offset)]
+	case address >= 0x6000:
+		if m.control&0x80 != 0 {
+			return m.SRAM[int(address)-0x6000]
+		}
+		return m.console.openBus
+	default:
+		log.Fatalf("unhandled mapper85 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper85) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		bank := address / 0x0400
+		offset := address % 0x0400
+		m.CHR[m.chrOffsets[bank]+int(offset)] = value
+	case address >= 0x8000:
+		m.writeRegister(address, value)
+	case address >= 0x6000:
+		if m.control&0x80 != 0 {
+			m.SRAM[int(address)-0x6000] = value
+		}
+	default:
+		log.Fatalf("unhandled mapper85 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper85) writeRegister(address uint16, value byte) {
+	// the audio ports need A5 as well, so check them before folding the
+	// address down to a register pair
+	switch address & 0xF030 {
+	case 0x9010:
+		m.audio.writeAddress(value)
+		return
+	case 0x9030:
+		m.audio.writeData(value)
+		return
+	}
+	second := address&m.selectLine != 0
+	switch address & 0xF000 {
+	case 0x8000:
+		if second {
+			m.prgBanks[1] = value & 0x3F
+		} else {
+			m.prgBanks[0] = value & 0x3F
+		}
+		m.updateOffsets()
+	case 0x9000:
+		if !second {
+			m.prgBanks[2] = value & 0x3F
+			m.updateOffsets()
+		}
+	case 0xA000, 0xB000, 0xC000, 0xD000:
+		index := (address - 0xA000) >> 12 * 2
+		if second {
+			index++
+		}
+		m.chrBanks[index] = value
+		m.updateOffsets()
+	case 0xE000:
+		if second {
+			m.irq.writeLatch(value)
+		} else {
+			m.writeControl(value)
+		}
+	case 0xF000:
+		if second {
+			m.irq.acknowledge()
+		} else {
+			m.irq.writeControl(value)
+		}
+	}
+}
+
+// writeControl handles $E000: mirroring, sound reset and PRG-RAM enable
+func (m *Mapper85) writeControl(value byte) {
+	m.control = value
+	switch value & 3 {
+	case 0:
+		m.Cartridge.Mirror = MirrorVertical
+	case 1:
+		m.Cartridge.Mirror = MirrorHorizontal
+	case 2:
+		m.Cartridge.Mirror = MirrorSingle0
+	case 3:
+		m.Cartridge.Mirror = MirrorSingle1
+	}
+	if value&0x40 != 0 {
+		m.audio = opll{}
+	}
+}
+
+func (m *Mapper85) prgBankOffset(index int) int {
+	index %= len(m.PRG) / 0x2000
+	offset := index * 0x2000
+	if offset < 0 {
+		offset += len(m.PRG)
+	}
+	return offset
+}
+
+func (m *Mapper85) chrBankOffset(index int) int {
+	index %= len(m.CHR) / 0x0400
+	return index * 0x0400
+}
+
+func (m *Mapper85) updateOffsets() {
+	for i, bank := range m.prgBanks {
+		m.prgOffsets[i] = m.prgBankOffset(int(bank))
+	}
+	m.prgOffsets[3] = m.prgBankOffset(-1)
+	for i, bank := range m.chrBanks {
+		m.chrOffsets[i] = m.chrBankOffset(int(bank))
+	}
+}
+
+// StepAudio runs the FM synthesizer unless it is held in reset by $E000
+func (m *Mapper85) StepAudio() {
+	if m.control&0x40 == 0 {
+		m.audio.step()
+	}
+}
+
+// AudioOutput scales the FM channels so that a single channel at full
+// volume peaks at the level of a full volume APU pulse
+func (m *Mapper85) AudioOutput() float32 {
+	if m.control&0x40 != 0 {
+		return 0
+	}
+	return float32(m.audio.output()) * pulseTable[15]
+}
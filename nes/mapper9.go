@@ -0,0 +1,135 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// Mapper9 is the MMC2, and with mapper 10 the MMC4. Each 4KB pattern table
+// has two CHR banks, chosen by a latch that flips when the PPU fetches tile
+// $FD or $FE from that table.
+type Mapper9 struct {
+	*Cartridge
+	mmc4      bool
+	prgBank   byte
+	chrBanks  [2][2]byte // pattern table, then latch $FD or $FE
+	latches   [2]byte
+	prgOffset int
+}
+
+func NewMapper9(cartridge *Cartridge) Mapper {
+	m := Mapper9{Cartridge: cartridge}
+	m.mmc4 = cartridge.Mapper == 10
+	m.latches = [2]byte{0xFE, 0xFE}
+	m.updateOffsets()
+	return &m
+}
+
+func (m *Mapper9) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.prgBank)
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.latches)
+	encoder.Encode(m.prgOffset)
+	return nil
+}
+
+func (m *Mapper9) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.prgBank)
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.latches)
+	decoder.Decode(&m.prgOffset)
+	return nil
+}
+
+func (m *Mapper9) Reset(hard bool) {
+	if hard {
+		*m = *NewMapper9(m.Cartridge).(*Mapper9)
+	}
+}
+
+func (m *Mapper9) Step() {
+}
+
+// WatchPatternFetch sets a latch after the PPU fetches the high plane of
+// tile $FD or $FE. In the left pattern table the MMC2 only reacts to the
+// first row of those tiles; elsewhere any row sets the latch.
+func (m *Mapper9) WatchPatternFetch(address uint16) {
+	table := address >> 12
+	tile := byte(address >> 4)
+	if (tile != 0xFD && tile != 0xFE) || address&8 == 0 {
+		return
+	}
+	if table == 0 && !m.mmc4 && address&7 != 0 {
+		return
+	}
+	m.latches[table] = tile
+}
+
+func (m *Mapper9) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		index := int(address) - 0x8000
+		if index < m.prgWindow() {
+			return m.PRG[m.prgOffset+index]
+		}
+		return m.PRG[len(m.PRG)-0x8000+index]
+	case address >= 0x6000:
+		return m.SRAM[int(address)-0x6000]
+	default:
+		log.Fatalf("unhandled mapper9 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper9) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		m.CHR[m.chrOffset(address)] = value
+	case address >= 0xF000:
+		m.writeMirror(value)
+	case address >= 0xB000:
+		index := (address - 0xB000) / 0x1000
+		m.chrBanks[index/2][index%2] = value & 0x1F
+	case address >= 0xA000:
+		m.prgBank = value & 0x0F
+		m.updateOffsets()
+	case address >= 0x8000:
+		// $8000-$9FFF has no registers
+	case address >= 0x6000:
+		m.SRAM[int(address)-0x6000] = value
+	default:
+		log.Fatalf("unhandled mapper9 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper9) writeMirror(value byte) {
+	switch value & 1 {
+	case 0:
+		m.Cartridge.Mirror = MirrorVertical
+	case 1:
+		m.Cartridge.Mirror = MirrorHorizontal
+	}
+}
+
+// prgWindow is the size of the switchable PRG bank at $8000: 8KB on the
+// MMC2 and 16KB on the MMC4, with the rest fixed to the end of PRG-ROM
+func (m *Mapper9) prgWindow() int {
+	if m.mmc4 {
+		return 0x4000
+	}
+	return 0x2000
+}
+
+func (m *Mapper9) chrOffset(address uint16) int {
+	table := address >> 12
+	bank := m.chrBanks[table][m.latches[table]-0xFD]
+	offset := int(bank)*0x1000 + int(address&0x0FFF)
+	return offset % len(m.CHR)
+}
+
+func (m *Mapper9) updateOffsets() {
+	size := m.prgWindow()
+	m.prgOffset = int(m.prgBank) * size % len(m.PRG)
+}
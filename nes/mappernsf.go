@@ -0,0 +1,362 @@
+package nes
+
+import (
+	"encoding/gob"
+	"log"
+)
+
+// the player's driver code sits in the otherwise unused $4100 page
+const (
+	nsfDriverAddress = 0x4100
+	nsfStatusAddress = 0x4130 // bit 7 is set when PLAY is due; cleared on read
+	nsfIdleAddress   = 0x412B // RTI for the NMI and IRQ vectors
+	nsfTrackOperand  = 0x1A
+	nsfRegionOperand = 0x1C
+	nsfInitOperand   = 0x1E
+	nsfPlayOperand   = 0x26
+)
+
+// nsfDriver silences the APU, calls INIT with the track in A and the region
+// in X, and then calls PLAY each time the status register says it is due
+var nsfDriver = []byte{
+	0x78,       // 00 SEI
+	0xD8,       // 01 CLD
+	0xA2, 0xFF, // 02 LDX #$FF
+	0x9A,             // 04 TXS
+	0xE8,             // 05 INX
+	0x8A,             // 06 TXA
+	0x9D, 0x00, 0x40, // 07 STA $4000,X
+	0xE8,       // 0A INX
+	0xE0, 0x14, // 0B CPX #$14
+	0xD0, 0xF8, // 0D BNE $4107
+	0xA9, 0x0F, // 0F LDA #$0F
+	0x8D, 0x15, 0x40, // 11 STA $4015
+	0xA9, 0x40, // 14 LDA #$40
+	0x8D, 0x17, 0x40, // 16 STA $4017
+	0xA9, 0x00, // 19 LDA #track
+	0xA2, 0x00, // 1B LDX #region
+	0x20, 0x00, 0x00, // 1D JSR init
+	0xAD, 0x30, 0x41, // 20 LDA $4130
+	0x10, 0xFB, // 23 BPL $4120
+	0x20, 0x00, 0x00, // 25 JSR play
+	0x4C, 0x20, 0x41, // 28 JMP $4120
+	0x40, // 2B RTI
+}
+
+// MapperNSF is the hardware of an NSF player: 4KB PRG bank registers at
+// $5FF8-$5FFF, PRG-RAM at $6000, the driver that calls the music code and
+// any expansion sound chips the file asks for. The chips are the mappers
+// that carry them, receiving only their sound register writes.
+type MapperNSF struct {
+	*Cartridge
+	console    *Console
+	nsf        *NSF
+	track      int
+	banks      [10]byte // 4KB windows at $6000-$FFFF
+	prgOffsets [10]int
+	fdsRAM     []byte // FDS tunes run from RAM at $6000-$DFFF
+	playTimer  float64
+	playDue    bool
+
+	// MMC5 ExRAM and multiplier
+	exRAM        [1024]byte
+	multiplicand byte
+	multiplier   byte
+
+	vrc6  Mapper
+	vrc7  Mapper
+	fds   Mapper
+	n163  Mapper
+	s5b   Mapper
+	chips []Mapper
+}
+
+func NewMapperNSF(console *Console, cartridge *Cartridge) Mapper {
+	nsf := cartridge.NSF
+	m := MapperNSF{Cartridge: cartridge, console: console, nsf: nsf}
+	m.track = nsf.StartingSong
+	if nsf.Chips&NSFChipFDS != 0 {
+		m.fdsRAM = make([]byte, 0x8000)
+	}
+	for i := range m.banks {
+		m.writeBank(i, byte(i))
+	}
+	if nsf.Bankswitched {
+		for i, bank := range nsf.Bankswitch {
+			m.writeBank(i+2, bank)
+		}
+		if m.fdsRAM != nil {
+			m.writeBank(0, nsf.Bankswitch[6])
+			m.writeBank(1, nsf.Bankswitch[7])
+		}
+	}
+	m.vrc6 = m.addChip(NSFChipVRC6, 24, NewMapper24)
+	m.vrc7 = m.addChip(NSFChipVRC7, 85, NewMapper85)
+	m.fds = m.addChip(NSFChipFDS, 20, NewMapper20)
+	m.n163 = m.addChip(NSFChipN163, 19, NewMapper19)
+	m.s5b = m.addChip(NSFChipSunsoft5B, 69, NewMapper69)
+	if m.fds != nil {
+		// enable the RAM adapter's sound registers
+		m.fds.(ExpansionAreaMapper).WriteExpansion(0x4023, 0x02)
+	}
+	return &m
+}
+
+// addChip creates the mapper carrying a sound chip if the file uses it,
+// with a cartridge of its own so that its banking cannot disturb the NSF
+func (m *MapperNSF) addChip(flag byte, mapper uint16,
+	newMapper func(*Console, *Cartridge) Mapper) Mapper {
+	if m.nsf.Chips&flag == 0 {
+		return nil
+	}
+	cartridge := NewCartridge(
+		make([]byte, 0x8000), make([]byte, 0x2000), mapper, MirrorHorizontal, 0)
+	chip := newMapper(m.console, cartridge)
+	m.chips = append(m.chips, chip)
+	return chip
+}
+
+func (m *MapperNSF) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.track)
+	encoder.Encode(m.banks)
+	encoder.Encode(m.prgOffsets)
+	encoder.Encode(m.fdsRAM)
+	encoder.Encode(m.playTimer)
+	encoder.Encode(m.playDue)
+	encoder.Encode(m.exRAM)
+	encoder.Encode(m.multiplicand)
+	encoder.Encode(m.multiplier)
+	for _, chip := range m.chips {
+		chip.Save(encoder)
+	}
+	return nil
+}
+
+func (m *MapperNSF) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.track)
+	decoder.Decode(&m.banks)
+	decoder.Decode(&m.prgOffsets)
+	decoder.Decode(&m.fdsRAM)
+	decoder.Decode(&m.playTimer)
+	decoder.Decode(&m.playDue)
+	decoder.Decode(&m.exRAM)
+	decoder.Decode(&m.multiplicand)
+	decoder.Decode(&m.multiplier)
+	for _, chip := range m.chips {
+		chip.Load(decoder)
+	}
+	return nil
+}
+
+// Reset restores the initial banks and chips, keeping the selected track.
+// The driver then starts the track from the reset vector.
+func (m *MapperNSF) Reset(hard bool) {
+	if hard {
+		track := m.track
+		*m = *NewMapperNSF(m.console, m.Cartridge).(*MapperNSF)
+		m.track = track
+	}
+}
+
+// Track returns the track being played, numbered from 0
+func (m *MapperNSF) Track() int {
+	return m.track
+}
+
+func (m *MapperNSF) Step() {
+}
+
+func (m *MapperNSF) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[address]
+	case address >= 0xFFFA:
+		return m.readVector(address)
+	case address >= 0x8000 || m.fdsRAM != nil:
+		window := int(address-0x6000) / 0x1000
+		if m.fdsRAM != nil && window < 8 {
+			return m.fdsRAM[address-0x6000]
+		}
+		offset := m.prgOffsets[window]
+		if offset < 0 {
+			return 0
+		}
+		return m.PRG[offset+int(address&0x0FFF)]
+	case address >= 0x6000:
+		return m.SRAM[address-0x6000]
+	default:
+		log.Fatalf("unhandled mapperNSF read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+// readVector points the reset vector at the driver and the interrupt
+// vectors at an RTI, as NSF players do
+func (m *MapperNSF) readVector(address uint16) byte {
+	switch address {
+	case 0xFFFC:
+		return byte(nsfDriverAddress & 0xFF)
+	case 0xFFFD:
+		return byte(nsfDriverAddress >> 8)
+	case 0xFFFA, 0xFFFE:
+		return byte(nsfIdleAddress & 0xFF)
+	default:
+		return byte(nsfIdleAddress >> 8)
+	}
+}
+
+func (m *MapperNSF) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		m.CHR[address] = value
+	case address >= 0x8000:
+		if m.fdsRAM != nil && address < 0xE000 {
+			m.fdsRAM[address-0x6000] = value
+		}
+		m.writeChips(address, value)
+	case address >= 0x6000:
+		if m.fdsRAM != nil {
+			m.fdsRAM[address-0x6000] = value
+		} else {
+			m.SRAM[address-0x6000] = value
+		}
+	default:
+		log.Fatalf("unhandled mapperNSF write at address: 0x%04X", address)
+	}
+}
+
+// writeChips passes sound register writes on to the expansion chips
+func (m *MapperNSF) writeChips(address uint16, value byte) {
+	if m.vrc6 != nil && address >= 0x9000 && address < 0xC000 {
+		if address&0x0FFF <= 2 || address == 0x9003 {
+			m.vrc6.Write(address, value)
+		}
+	}
+	if m.vrc7 != nil && (address == 0x9010 || address == 0x9030) {
+		m.vrc7.Write(address, value)
+	}
+	if m.n163 != nil && address >= 0xF800 {
+		m.n163.Write(address, value)
+	}
+	if m.s5b != nil && address >= 0xC000 {
+		m.s5b.Write(address, value)
+	}
+}
+
+func (m *MapperNSF) ReadExpansion(address uint16) byte {
+	switch {
+	case address == nsfStatusAddress:
+		if m.playDue {
+			m.playDue = false
+			return 0x80
+		}
+		return 0
+	case address >= nsfDriverAddress && int(address) < nsfDriverAddress+len(nsfDriver):
+		return m.readDriver(int(address - nsfDriverAddress))
+	case m.fds != nil && address >= 0x4040 && address < 0x40A0:
+		return m.fds.(ExpansionAreaMapper).ReadExpansion(address)
+	case m.n163 != nil && address >= 0x4800 && address < 0x5000:
+		return m.n163.(ExpansionAreaMapper).ReadExpansion(address)
+	case m.nsf.Chips&NSFChipMMC5 != 0 && address == 0x5205:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier))
+	case m.nsf.Chips&NSFChipMMC5 != 0 && address == 0x5206:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier) >> 8)
+	case m.nsf.Chips&NSFChipMMC5 != 0 && address >= 0x5C00 && address < 0x5FF6:
+		return m.exRAM[address-0x5C00]
+	}
+	return m.console.openBus
+}
+
+// readDriver returns a byte of the driver code, filling in the operands
+// that depend on the file and the selected track
+func (m *MapperNSF) readDriver(index int) byte {
+	switch index {
+	case nsfTrackOperand:
+		return byte(m.track)
+	case nsfRegionOperand:
+		if m.console.Region() != RegionNTSC {
+			return 1
+		}
+		return 0
+	case nsfInitOperand:
+		return byte(m.nsf.InitAddress)
+	case nsfInitOperand + 1:
+		return byte(m.nsf.InitAddress >> 8)
+	case nsfPlayOperand:
+		return byte(m.nsf.PlayAddress)
+	case nsfPlayOperand + 1:
+		return byte(m.nsf.PlayAddress >> 8)
+	}
+	return nsfDriver[index]
+}
+
+func (m *MapperNSF) WriteExpansion(address uint16, value byte) {
+	switch {
+	case address >= 0x5FF8:
+		m.writeBank(int(address-0x5FF8)+2, value)
+	case address >= 0x5FF6:
+		if m.fdsRAM != nil {
+			m.writeBank(int(address-0x5FF6), value)
+		}
+	case m.fds != nil && address >= 0x4040 && address < 0x40A0:
+		m.fds.(ExpansionAreaMapper).WriteExpansion(address, value)
+	case m.n163 != nil && address >= 0x4800 && address < 0x5000:
+		m.n163.(ExpansionAreaMapper).WriteExpansion(address, value)
+	case m.nsf.Chips&NSFChipMMC5 != 0 && address == 0x5205:
+		m.multiplicand = value
+	case m.nsf.Chips&NSFChipMMC5 != 0 && address == 0x5206:
+		m.multiplier = value
+	case m.nsf.Chips&NSFChipMMC5 != 0 && address >= 0x5C00:
+		m.exRAM[address-0x5C00] = value
+	}
+}
+
+// writeBank maps a 4KB PRG bank into a window. FDS tunes copy the bank into
+// RAM instead for the windows below $E000.
+func (m *MapperNSF) writeBank(window int, bank byte) {
+	m.banks[window] = bank
+	offset := int(bank) * 0x1000
+	if offset >= len(m.PRG) {
+		offset = -1
+	}
+	m.prgOffsets[window] = offset
+	if m.fdsRAM != nil && window < 8 {
+		ram := m.fdsRAM[window*0x1000 : window*0x1000+0x1000]
+		if offset < 0 {
+			for i := range ram {
+				ram[i] = 0
+			}
+		} else {
+			copy(ram, m.PRG[offset:])
+		}
+	}
+}
+
+// StepAudio also counts CPU cycles until the next PLAY call, at the rate
+// the file gives for the console's region
+func (m *MapperNSF) StepAudio() {
+	speed := m.nsf.NTSCSpeed
+	if m.console.Region() != RegionNTSC {
+		speed = m.nsf.PALSpeed
+	}
+	if speed == 0 {
+		speed = 16639
+	}
+	m.playTimer++
+	period := float64(speed) * m.console.CPUFrequency() / 1000000
+	if m.playTimer >= period {
+		m.playTimer -= period
+		m.playDue = !m.nsf.NoPlay
+	}
+	for _, chip := range m.chips {
+		chip.(ExpansionAudio).StepAudio()
+	}
+}
+
+func (m *MapperNSF) AudioOutput() float32 {
+	var output float32
+	for _, chip := range m.chips {
+		output += chip.(ExpansionAudio).AudioOutput()
+	}
+	return output
+}
@@ -17,22 +17,40 @@ func NewCPUMemory(console *Console) Memory {
 	return &cpuMemory{console}
 }
 
+// Read returns the value at an address and leaves it on the data bus.
+// Addresses nothing answers return the last value on the bus, which is
+// usually the high byte of the address just read from the instruction.
 func (mem *cpuMemory) Read(address uint16) byte {
+	if address == 0x4015 {
+		// the status register is inside the CPU, so the read does not
+		// reach the data bus and bit 5 is whatever was left on it
+		return mem.console.APU.readRegister(address) | mem.console.openBus&0x20
+	}
+	value := mem.read(address)
+	mem.console.openBus = value
+	return value
+}
+
+func (mem *cpuMemory) read(address uint16) byte {
+	openBus := mem.console.openBus
 	switch {
 	case address < 0x2000:
 		return mem.console.RAM[address%0x0800]
 	case address < 0x4000:
 		return mem.console.PPU.readRegister(0x2000 + address%8)
-	case address == 0x4014:
-		return mem.console.PPU.readRegister(address)
-	case address == 0x4015:
-		return mem.console.APU.readRegister(address)
+	case address < 0x4016:
+		// write-only APU and OAM DMA registers
+		return openBus
 	case address == 0x4016:
-		return mem.console.Controller1.Read()
+		// only the low bits are driven by the controller port
+		return openBus&0xE0 | mem.console.Controller1.Read()
 	case address == 0x4017:
-		return mem.console.Controller2.Read()
+		return openBus&0xE0 | mem.console.Controller2.Read()
 	case address < 0x6000:
-		// TODO: I/O registers
+		if mapper, ok := mem.console.Mapper.(ExpansionAreaMapper); ok {
+			return mapper.ReadExpansion(address)
+		}
+		return openBus
 	case address >= 0x6000:
 		return mem.console.Mapper.Read(address)
 	default:
@@ -42,6 +60,7 @@ func (mem *cpuMemory) Read(address uint16) byte {
 }
 
 func (mem *cpuMemory) Write(address uint16, value byte) {
+	mem.console.openBus = value
 	switch {
 	case address < 0x2000:
 		mem.console.RAM[address%0x0800] = value
@@ -59,7 +78,9 @@ func (mem *cpuMemory) Write(address uint16, value byte) {
 	case address == 0x4017:
 		mem.console.APU.writeRegister(address, value)
 	case address < 0x6000:
-		// TODO: I/O registers
+		if mapper, ok := mem.console.Mapper.(ExpansionAreaMapper); ok {
+			mapper.WriteExpansion(address, value)
+		}
 	case address >= 0x6000:
 		mem.console.Mapper.Write(address, value)
 	default:
@@ -79,12 +100,16 @@ func NewPPUMemory(console *Console) Memory {
 
 func (mem *ppuMemory) Read(address uint16) byte {
 	address = address % 0x4000
+	mem.console.PPU.setBusAddress(address)
 	switch {
 	case address < 0x2000:
 		return mem.console.Mapper.Read(address)
 	case address < 0x3F00:
-		mode := mem.console.Cartridge.Mirror
-		return mem.console.PPU.nameTableData[MirrorAddress(mode, address)%2048]
+		if mapper := mem.console.PPU.nameTables; mapper != nil {
+			return mapper.ReadNameTable(address)
+		}
+		ram, index := mem.nameTable(address)
+		return ram[index]
 	case address < 0x4000:
 		return mem.console.PPU.readPalette(address % 32)
 	default:
@@ -95,12 +120,17 @@ func (mem *ppuMemory) Read(address uint16) byte {
 
 func (mem *ppuMemory) Write(address uint16, value byte) {
 	address = address % 0x4000
+	mem.console.PPU.setBusAddress(address)
 	switch {
 	case address < 0x2000:
 		mem.console.Mapper.Write(address, value)
 	case address < 0x3F00:
-		mode := mem.console.Cartridge.Mirror
-		mem.console.PPU.nameTableData[MirrorAddress(mode, address)%2048] = value
+		if mapper := mem.console.PPU.nameTables; mapper != nil {
+			mapper.WriteNameTable(address, value)
+			return
+		}
+		ram, index := mem.nameTable(address)
+		ram[index] = value
 	case address < 0x4000:
 		mem.console.PPU.writePalette(address%32, value)
 	default:
@@ -108,6 +138,18 @@ func (mem *ppuMemory) Write(address uint16, value byte) {
 	}
 }
 
+// nameTable returns the RAM backing a nametable address and the index into
+// it. The console holds two nametables; four-screen boards supply the other
+// two from cartridge VRAM.
+func (mem *ppuMemory) nameTable(address uint16) ([]byte, int) {
+	cartridge := mem.console.Cartridge
+	index := int(MirrorAddress(cartridge.Mirror, address)-0x2000) % 4096
+	if index >= 2048 && len(cartridge.VRAM) >= 2048 {
+		return cartridge.VRAM, index - 2048
+	}
+	return mem.console.PPU.nameTableData[:], index % 2048
+}
+
 // Mirroring Modes
 
 const (
@@ -0,0 +1,283 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+)
+
+// expansion sound chips an NSF can use
+const (
+	NSFChipVRC6 = 1 << iota
+	NSFChipVRC7
+	NSFChipFDS
+	NSFChipMMC5
+	NSFChipN163
+	NSFChipSunsoft5B
+)
+
+const nsfHeaderSize = 0x80
+
+var nsfMagic = []byte("NESM\x1a")
+
+var nsfeMagic = []byte("NSFE")
+
+// NSF describes a music file for the NES. The program data is loaded into
+// the cartridge PRG; the rest is the information needed to play it.
+type NSF struct {
+	TotalSongs   int
+	StartingSong int // numbered from 0
+	LoadAddress  uint16
+	InitAddress  uint16
+	PlayAddress  uint16
+	NTSCSpeed    uint16 // microseconds between PLAY calls
+	PALSpeed     uint16
+	Bankswitch   [8]byte // initial banks at $8000-$FFFF
+	Bankswitched bool
+	Region       byte // bit 0: PAL; bit 1: both NTSC and PAL
+	Chips        byte // expansion sound chips
+	NoPlay       bool // NSF2: PLAY is never called
+
+	// metadata from the header, NSFe chunks or NSF2 metadata
+	Name         string
+	Artist       string
+	Copyright    string
+	Ripper       string
+	TrackNames   []string
+	TrackLengths []int // milliseconds, or -1 if unknown
+	TrackFades   []int // milliseconds, or -1 if unknown
+	Playlist     []int
+}
+
+// isNSFFile reports whether data starts like an NSF or NSFe file
+func isNSFFile(data []byte) bool {
+	return bytes.HasPrefix(data, nsfMagic) || bytes.HasPrefix(data, nsfeMagic)
+}
+
+// LoadNSFFile reads an NSF, NSF2 or NSFe file and returns a Cartridge for
+// the built-in player, with the file's details in Cartridge.NSF
+// http://wiki.nesdev.com/w/index.php/NSF
+// http://wiki.nesdev.com/w/index.php/NSF2
+// http://wiki.nesdev.com/w/index.php/NSFe
+func LoadNSFFile(path string) (*Cartridge, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	nsf := &NSF{}
+	var program []byte
+	if bytes.HasPrefix(data, nsfeMagic) {
+		program, err = nsf.readChunks(data[len(nsfeMagic):], true)
+	} else {
+		program, err = nsf.readHeader(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if nsf.TotalSongs == 0 {
+		return nil, errors.New("NSF has no songs")
+	}
+	if nsf.StartingSong >= nsf.TotalSongs {
+		nsf.StartingSong = 0
+	}
+	prg := nsf.buildPRG(program)
+	cartridge := NewCartridge(prg, make([]byte, 0x2000), 0, MirrorHorizontal, 0)
+	cartridge.NSF = nsf
+	if nsf.Region&3 == 1 {
+		cartridge.Region = RegionPAL
+	}
+	return cartridge, nil
+}
+
+// readHeader parses a classic NSF header and any NSF2 metadata, returning
+// the program data
+func (nsf *NSF) readHeader(data []byte) ([]byte, error) {
+	if len(data) < nsfHeaderSize || !bytes.HasPrefix(data, nsfMagic) {
+		return nil, errors.New("invalid .nsf file")
+	}
+	version := data[5]
+	nsf.TotalSongs = int(data[6])
+	nsf.StartingSong = int(data[7]) - 1
+	if nsf.StartingSong < 0 {
+		nsf.StartingSong = 0
+	}
+	nsf.LoadAddress = binary.LittleEndian.Uint16(data[8:])
+	nsf.InitAddress = binary.LittleEndian.Uint16(data[10:])
+	nsf.PlayAddress = binary.LittleEndian.Uint16(data[12:])
+	nsf.Name = nulString(data[0x0E:0x2E])
+	nsf.Artist = nulString(data[0x2E:0x4E])
+	nsf.Copyright = nulString(data[0x4E:0x6E])
+	nsf.NTSCSpeed = binary.LittleEndian.Uint16(data[0x6E:])
+	copy(nsf.Bankswitch[:], data[0x70:0x78])
+	nsf.PALSpeed = binary.LittleEndian.Uint16(data[0x78:])
+	nsf.Region = data[0x7A]
+	nsf.Chips = data[0x7B]
+	for _, bank := range nsf.Bankswitch {
+		if bank != 0 {
+			nsf.Bankswitched = true
+		}
+	}
+
+	program := data[nsfHeaderSize:]
+	if version < 2 {
+		return program, nil
+	}
+	// NSF2 adds flags and an optional block of NSFe chunks after the
+	// program data
+	nsf.NoPlay = data[0x7C]&0x40 != 0
+	length := int(data[0x7D]) | int(data[0x7E])<<8 | int(data[0x7F])<<16
+	if length == 0 || length > len(program) {
+		return program, nil
+	}
+	if _, err := nsf.readChunks(program[length:], false); err != nil {
+		return nil, err
+	}
+	return program[:length], nil
+}
+
+// readChunks parses NSFe chunks. A full NSFe file must describe the program
+// with INFO and DATA, while NSF2 metadata only adds to the header.
+func (nsf *NSF) readChunks(data []byte, full bool) ([]byte, error) {
+	var program []byte
+	hasInfo := false
+	for len(data) >= 8 {
+		length := int(binary.LittleEndian.Uint32(data))
+		id := string(data[4:8])
+		data = data[8:]
+		if length < 0 || length > len(data) {
+			return nil, errors.New("truncated NSFe chunk: " + id)
+		}
+		chunk := data[:length]
+		data = data[length:]
+		switch id {
+		case "INFO":
+			if len(chunk) < 9 {
+				return nil, errors.New("invalid NSFe INFO chunk")
+			}
+			nsf.LoadAddress = binary.LittleEndian.Uint16(chunk[0:])
+			nsf.InitAddress = binary.LittleEndian.Uint16(chunk[2:])
+			nsf.PlayAddress = binary.LittleEndian.Uint16(chunk[4:])
+			nsf.Region = chunk[6]
+			nsf.Chips = chunk[7]
+			nsf.TotalSongs = int(chunk[8])
+			if len(chunk) > 9 {
+				nsf.StartingSong = int(chunk[9])
+			}
+			nsf.NTSCSpeed = 16639
+			nsf.PALSpeed = 19997
+			hasInfo = true
+		case "DATA":
+			program = chunk
+		case "BANK":
+			copy(nsf.Bankswitch[:], chunk)
+			nsf.Bankswitched = true
+		case "RATE":
+			if len(chunk) >= 2 {
+				nsf.NTSCSpeed = binary.LittleEndian.Uint16(chunk)
+			}
+			if len(chunk) >= 4 {
+				nsf.PALSpeed = binary.LittleEndian.Uint16(chunk[2:])
+			}
+		case "auth":
+			strings := nsfStrings(chunk)
+			for len(strings) < 4 {
+				strings = append(strings, "")
+			}
+			nsf.Name, nsf.Artist = strings[0], strings[1]
+			nsf.Copyright, nsf.Ripper = strings[2], strings[3]
+		case "tlbl":
+			nsf.TrackNames = nsfStrings(chunk)
+		case "time":
+			nsf.TrackLengths = nsfTimes(chunk)
+		case "fade":
+			nsf.TrackFades = nsfTimes(chunk)
+		case "plst":
+			nsf.Playlist = nil
+			for _, track := range chunk {
+				nsf.Playlist = append(nsf.Playlist, int(track))
+			}
+		case "NEND":
+			data = nil
+		default:
+			// chunks starting with a capital letter must be understood
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return nil, errors.New("unsupported NSFe chunk: " + id)
+			}
+		}
+	}
+	if full && (!hasInfo || program == nil) {
+		return nil, errors.New("invalid .nsfe file")
+	}
+	return program, nil
+}
+
+// buildPRG lays the program out in 4KB banks. Bankswitched programs are
+// padded so that the load address falls at its offset within the first
+// bank; others are placed at their load address in a 40KB image covering
+// $6000-$FFFF, which the player maps as if bankswitched.
+func (nsf *NSF) buildPRG(program []byte) []byte {
+	if nsf.Bankswitched {
+		padding := int(nsf.LoadAddress & 0x0FFF)
+		size := (padding + len(program) + 0x0FFF) &^ 0x0FFF
+		prg := make([]byte, size)
+		copy(prg[padding:], program)
+		return prg
+	}
+	prg := make([]byte, 0xA000)
+	if nsf.LoadAddress >= 0x6000 {
+		copy(prg[nsf.LoadAddress-0x6000:], program)
+	}
+	return prg
+}
+
+// TrackName returns the name of a track, or "" if the file has none
+func (nsf *NSF) TrackName(track int) string {
+	if track < len(nsf.TrackNames) {
+		return nsf.TrackNames[track]
+	}
+	return ""
+}
+
+// TrackLength returns how long a track plays and then fades out for, in
+// milliseconds, or -1 if the file does not say
+func (nsf *NSF) TrackLength(track int) (length, fade int) {
+	length, fade = -1, -1
+	if track < len(nsf.TrackLengths) {
+		length = nsf.TrackLengths[track]
+	}
+	if track < len(nsf.TrackFades) {
+		fade = nsf.TrackFades[track]
+	}
+	return
+}
+
+func nulString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+func nsfStrings(data []byte) []string {
+	var result []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			result = append(result, string(data))
+			break
+		}
+		result = append(result, string(data[:i]))
+		data = data[i+1:]
+	}
+	return result
+}
+
+func nsfTimes(data []byte) []int {
+	var result []int
+	for len(data) >= 4 {
+		result = append(result, int(int32(binary.LittleEndian.Uint32(data))))
+		data = data[4:]
+	}
+	return result
+}
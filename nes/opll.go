@@ -0,0 +1,310 @@
+package nes
+
+import (
+	"encoding/gob"
+	"math"
+)
+
+// OPLL is a small model of the YM2413-derived FM synthesizer inside the
+// Konami VRC7. It has six two-operator channels, each playing either one of
+// fifteen fixed instrument patches or a single user-defined patch. The
+// model works in floating point rather than reproducing the chip's log-sin
+// tables, but follows its register layout, envelope states and LFOs.
+
+// opllCycles is the number of CPU cycles per OPLL sample; the chip produces
+// one sample every 72 cycles of its 3.58 MHz clock
+const opllCycles = 36
+
+const opllSampleRate = 3579545.0 / 72
+
+// opllPatches are the VRC7's built-in instruments 1 through 15, in the
+// same layout as the custom instrument registers $00-$07
+var opllPatches = [15][8]byte{
+	{0x03, 0x21, 0x05, 0x06, 0xE8, 0x81, 0x42, 0x27}, // buzzy bell
+	{0x13, 0x41, 0x14, 0x0D, 0xD8, 0xF6, 0x23, 0x12}, // guitar
+	{0x11, 0x11, 0x08, 0x08, 0xFA, 0xB2, 0x20, 0x12}, // wurly
+	{0x31, 0x61, 0x0C, 0x07, 0xA8, 0x64, 0x61, 0x27}, // flute
+	{0x32, 0x21, 0x1E, 0x06, 0xE1, 0x76, 0x01, 0x28}, // clarinet
+	{0x02, 0x01, 0x06, 0x00, 0xA3, 0xE2, 0xF4, 0xF4}, // synth
+	{0x21, 0x61, 0x1D, 0x07, 0x82, 0x81, 0x11, 0x07}, // trumpet
+	{0x23, 0x21, 0x22, 0x17, 0xA2, 0x72, 0x01, 0x17}, // organ
+	{0x35, 0x11, 0x25, 0x00, 0x40, 0x73, 0x72, 0x01}, // bells
+	{0xB5, 0x01, 0x0F, 0x0F, 0xA8, 0xA5, 0x51, 0x02}, // vibes
+	{0x17, 0xC1, 0x24, 0x07, 0xF8, 0xF8, 0x22, 0x12}, // vibraphone
+	{0x71, 0x23, 0x11, 0x06, 0x65, 0x74, 0x18, 0x16}, // tutti
+	{0x01, 0x02, 0xD3, 0x05, 0xC9, 0x95, 0x03, 0x02}, // fretless
+	{0x61, 0x63, 0x0C, 0x00, 0x94, 0xC0, 0x33, 0xF6}, // synth bass
+	{0x21, 0x72, 0x0D, 0x00, 0xC1, 0xD5, 0x56, 0x06}, // sweep
+}
+
+// opllMultiples are the frequency multipliers selected by MULT
+var opllMultiples = [16]float64{
+	0.5, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 10, 12, 12, 15, 15,
+}
+
+// opllKeyScale is the key scale attenuation in dB for the top four bits of
+// the frequency number at octave 7, before scaling by the KSL setting
+var opllKeyScale = [16]float64{
+	0, 18, 24, 27.75, 30, 32.25, 33.75, 35.25,
+	36, 37.5, 38.25, 39, 39.75, 40.5, 41.25, 42,
+}
+
+// opllKeyScaleShift converts KSL 0-3 to 0, 1.5, 3 and 6 dB per octave
+var opllKeyScaleShift = [4]float64{0, 0.25, 0.5, 1}
+
+// envelope states
+const (
+	envelopeOff = iota
+	envelopeAttack
+	envelopeDecay
+	envelopeSustain
+	envelopeRelease
+)
+
+// opllMaxAttenuation is the envelope level at which an operator is silent
+const opllMaxAttenuation = 48
+
+type opllOperator struct {
+	phase    float64    // position within the waveform, in cycles
+	envelope byte       // envelope state
+	level    float64    // envelope attenuation in dB
+	output   [2]float64 // last two outputs, for modulator feedback
+}
+
+func (o *opllOperator) Save(encoder *gob.Encoder) error {
+	encoder.Encode(o.phase)
+	encoder.Encode(o.envelope)
+	encoder.Encode(o.level)
+	encoder.Encode(o.output)
+	return nil
+}
+
+func (o *opllOperator) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&o.phase)
+	decoder.Decode(&o.envelope)
+	decoder.Decode(&o.level)
+	decoder.Decode(&o.output)
+	return nil
+}
+
+func (o *opllOperator) keyOn() {
+	o.phase = 0
+	o.envelope = envelopeAttack
+}
+
+func (o *opllOperator) keyOff() {
+	if o.envelope != envelopeOff {
+		o.envelope = envelopeRelease
+	}
+}
+
+// stepEnvelope advances the envelope generator by one sample. patch holds
+// the operator's settings: index 0 for AM/VIB/EG/KSR/MULT, 4 for AR/DR and
+// 6 for SL/RR.
+func (o *opllOperator) stepEnvelope(patch [8]byte, op int, keyScale int, sustain bool) {
+	var rate byte
+	switch o.envelope {
+	case envelopeAttack:
+		rate = patch[4+op] >> 4
+	case envelopeDecay:
+		rate = patch[4+op] & 15
+	case envelopeSustain:
+		// percussive tones keep decaying at the release rate
+		if patch[op]&0x20 == 0 {
+			rate = patch[6+op] & 15
+		}
+	case envelopeRelease:
+		switch {
+		case sustain:
+			rate = 5
+		case patch[op]&0x20 != 0:
+			rate = patch[6+op] & 15
+		default:
+			rate = 7
+		}
+	default:
+		return
+	}
+	if patch[op]&0x10 == 0 {
+		keyScale >>= 2
+	}
+	step := 0.0
+	if rate != 0 {
+		index := math.Min(float64(rate)*4+float64(keyScale), 63)
+		step = math.Pow(2, index/4)
+	}
+	switch o.envelope {
+	case envelopeAttack:
+		// the attack curve is exponential; a rate of 15 is instant
+		if rate == 15 {
+			o.level = 0
+		} else {
+			o.level -= step * (1 + o.level/6) * 13.18 / (2.826 * 2 * opllSampleRate)
+		}
+		if o.level <= 0 {
+			o.level = 0
+			o.envelope = envelopeDecay
+		}
+	case envelopeDecay:
+		o.level += step * 96 / (39.28 * 2 * opllSampleRate)
+		if sustainLevel := float64(patch[6+op]>>4) * 3; o.level >= sustainLevel {
+			o.level = sustainLevel
+			o.envelope = envelopeSustain
+		}
+	default:
+		o.level += step * 96 / (39.28 * 2 * opllSampleRate)
+		if o.level >= opllMaxAttenuation {
+			o.level = opllMaxAttenuation
+			o.envelope = envelopeOff
+		}
+	}
+}
+
+// compute returns one sample of the operator, with the waveform's phase
+// shifted by modulation radians and attenuated by attenuation dB
+func (o *opllOperator) compute(modulation, attenuation float64, rectify bool) float64 {
+	if o.envelope == envelopeOff {
+		return 0
+	}
+	output := math.Sin(2*math.Pi*o.phase + modulation)
+	if rectify && output < 0 {
+		output = 0
+	}
+	return output * math.Pow(10, -(attenuation+o.level)/20)
+}
+
+type opll struct {
+	address   byte
+	registers [0x40]byte
+	operators [12]opllOperator // modulator and carrier of each channel
+	amPhase   float64
+	pmPhase   float64
+	cycles    int
+	sample    float64
+}
+
+func (o *opll) Save(encoder *gob.Encoder) error {
+	encoder.Encode(o.address)
+	encoder.Encode(o.registers)
+	for i := range o.operators {
+		o.operators[i].Save(encoder)
+	}
+	encoder.Encode(o.amPhase)
+	encoder.Encode(o.pmPhase)
+	encoder.Encode(o.cycles)
+	encoder.Encode(o.sample)
+	return nil
+}
+
+func (o *opll) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&o.address)
+	decoder.Decode(&o.registers)
+	for i := range o.operators {
+		o.operators[i].Load(decoder)
+	}
+	decoder.Decode(&o.amPhase)
+	decoder.Decode(&o.pmPhase)
+	decoder.Decode(&o.cycles)
+	decoder.Decode(&o.sample)
+	return nil
+}
+
+func (o *opll) writeAddress(value byte) {
+	o.address = value
+}
+
+func (o *opll) writeData(value byte) {
+	address := o.address & 0x3F
+	channel := int(address & 0x0F)
+	if address >= 0x10 && channel > 5 {
+		return
+	}
+	previous := o.registers[address]
+	o.registers[address] = value
+	// bit 4 of $20-$25 keys the channel on and off
+	if address&0xF0 == 0x20 && (previous^value)&0x10 != 0 {
+		modulator := &o.operators[channel*2]
+		carrier := &o.operators[channel*2+1]
+		if value&0x10 != 0 {
+			modulator.keyOn()
+			carrier.keyOn()
+		} else {
+			modulator.keyOff()
+			carrier.keyOff()
+		}
+	}
+}
+
+// patch returns the instrument settings of a channel
+func (o *opll) patch(channel int) [8]byte {
+	instrument := o.registers[0x30+channel] >> 4
+	if instrument == 0 {
+		var patch [8]byte
+		copy(patch[:], o.registers[:8])
+		return patch
+	}
+	return opllPatches[instrument-1]
+}
+
+// step is called once per CPU cycle and generates a new sample when due
+func (o *opll) step() {
+	o.cycles++
+	if o.cycles < opllCycles {
+		return
+	}
+	o.cycles = 0
+	o.amPhase = math.Mod(o.amPhase+3.7/opllSampleRate, 1)
+	o.pmPhase = math.Mod(o.pmPhase+6.4/opllSampleRate, 1)
+	o.sample = 0
+	for channel := 0; channel < 6; channel++ {
+		o.sample += o.computeChannel(channel)
+	}
+}
+
+func (o *opll) computeChannel(channel int) float64 {
+	patch := o.patch(channel)
+	fnum := int(o.registers[0x10+channel]) | int(o.registers[0x20+channel]&1)<<8
+	block := int(o.registers[0x20+channel]>>1) & 7
+	sustain := o.registers[0x20+channel]&0x20 != 0
+	volume := float64(o.registers[0x30+channel]&15) * 3
+	keyScale := block<<1 | fnum>>8
+	keyScaleLevel := math.Max(opllKeyScale[fnum>>5]-6*float64(7-block), 0)
+	tremolo := 4.8 * (1 - math.Cos(2*math.Pi*o.amPhase)) / 2
+	vibrato := math.Pow(2, 13.75/1200*math.Sin(2*math.Pi*o.pmPhase))
+	frequency := float64(fnum) * math.Pow(2, float64(block)-19) * opllSampleRate
+	var outputs [2]float64
+	for op := 0; op < 2; op++ {
+		operator := &o.operators[channel*2+op]
+		operator.stepEnvelope(patch, op, keyScale, sustain)
+		increment := frequency * opllMultiples[patch[op]&15] / opllSampleRate
+		if patch[op]&0x40 != 0 {
+			increment *= vibrato
+		}
+		attenuation := keyScaleLevel * opllKeyScaleShift[patch[2+op]>>6]
+		if patch[op]&0x80 != 0 {
+			attenuation += tremolo
+		}
+		var modulation float64
+		if op == 0 {
+			attenuation += float64(patch[2]&0x3F) * 0.75
+			if feedback := patch[3] & 7; feedback != 0 {
+				average := (operator.output[0] + operator.output[1]) / 2
+				modulation = average * 4 * math.Pi / float64(int(1)<<(7-feedback))
+			}
+		} else {
+			attenuation += volume
+			modulation = outputs[0] * 4 * math.Pi
+		}
+		rectify := patch[3]&(0x08<<uint(op)) != 0
+		outputs[op] = operator.compute(modulation, attenuation, rectify)
+		operator.output[1] = operator.output[0]
+		operator.output[0] = outputs[op]
+		operator.phase = math.Mod(operator.phase+increment, 1)
+	}
+	return outputs[1]
+}
+
+// output returns the last sample, summed over all channels
+func (o *opll) output() float64 {
+	return o.sample
+}
@@ -4,6 +4,14 @@ import "image/color"
 
 var Palette [64]color.RGBA
 
+// EmphasisPalettes holds a copy of Palette for each combination of the
+// PPUMASK color emphasis bits (bit 0: red, bit 1: green, bit 2: blue)
+var EmphasisPalettes [8][64]color.RGBA
+
+// emphasisFactor is the attenuation applied to the channels that are not
+// emphasized
+const emphasisFactor = 0.816328
+
 func init() {
 	colors := []uint32{
 		0x666666, 0x002A88, 0x1412A7, 0x3B00A4, 0x5C007E, 0x6E0040, 0x6C0600, 0x561D00,
@@ -21,4 +29,22 @@ func init() {
 		b := byte(c)
 		Palette[i] = color.RGBA{r, g, b, 0xFF}
 	}
+	for emphasis := range EmphasisPalettes {
+		for i, c := range Palette {
+			// the blacks in columns $xE and $xF are not affected
+			if emphasis != 0 && i%16 < 14 {
+				c.R = attenuate(c.R, emphasis&1 == 0)
+				c.G = attenuate(c.G, emphasis&2 == 0)
+				c.B = attenuate(c.B, emphasis&4 == 0)
+			}
+			EmphasisPalettes[emphasis][i] = c
+		}
+	}
+}
+
+func attenuate(value byte, dim bool) byte {
+	if !dim {
+		return value
+	}
+	return byte(float64(value) * emphasisFactor)
 }
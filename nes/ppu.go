@@ -3,14 +3,29 @@ package nes
 import (
 	"encoding/gob"
 	"image"
+	"image/color"
+)
+
+// kinds of PPU memory access, so that mappers can tell rendering fetches
+// apart from CPU accesses through $2007
+const (
+	ppuAccessData       = iota // $2007 read or write
+	ppuAccessNameTable         // background nametable fetch
+	ppuAccessAttribute         // background attribute fetch
+	ppuAccessBackground        // background pattern fetch
+	ppuAccessSprite            // sprite pattern fetch
 )
 
 type PPU struct {
-	Memory           // memory interface
-	console *Console // reference to parent object
+	Memory                             // memory interface
+	console        *Console            // reference to parent object
+	busWatcher     PPUBusWatcher       // mapper observing the address bus, if any
+	patternWatcher PatternFetchWatcher // mapper observing pattern fetches, if any
+	nameTables     NameTableMapper     // mapper controlling nametable memory, if any
+	access         byte                // kind of the memory access in progress
 
 	Cycle    int    // 0-340
-	ScanLine int    // 0-261, 0-239=visible, 240=post, 241-260=vblank, 261=pre
+	ScanLine int    // 0-261, 0-239=visible, 240=post, 241-260=vblank, 261=pre (NTSC)
 	Frame    uint64 // frame counter
 
 	// storage variables
@@ -27,7 +42,8 @@ type PPU struct {
 	w byte   // write toggle (1 bit)
 	f byte   // even/odd frame flag (1 bit)
 
-	register byte
+	register     byte
+	ignoreWrites bool // set by reset until the next pre-render line
 
 	// NMI flags
 	nmiOccurred bool
@@ -80,9 +96,12 @@ type PPU struct {
 
 func NewPPU(console *Console) *PPU {
 	ppu := PPU{Memory: NewPPUMemory(console), console: console}
+	ppu.busWatcher, _ = console.Mapper.(PPUBusWatcher)
+	ppu.patternWatcher, _ = console.Mapper.(PatternFetchWatcher)
+	ppu.nameTables, _ = console.Mapper.(NameTableMapper)
 	ppu.front = image.NewRGBA(image.Rect(0, 0, 256, 240))
 	ppu.back = image.NewRGBA(image.Rect(0, 0, 256, 240))
-	ppu.Reset()
+	ppu.PowerCycle()
 	return &ppu
 }
 
@@ -99,6 +118,7 @@ func (ppu *PPU) Save(encoder *gob.Encoder) error {
 	encoder.Encode(ppu.w)
 	encoder.Encode(ppu.f)
 	encoder.Encode(ppu.register)
+	encoder.Encode(ppu.ignoreWrites)
 	encoder.Encode(ppu.nmiOccurred)
 	encoder.Encode(ppu.nmiOutput)
 	encoder.Encode(ppu.nmiPrevious)
@@ -147,6 +167,7 @@ func (ppu *PPU) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&ppu.w)
 	decoder.Decode(&ppu.f)
 	decoder.Decode(&ppu.register)
+	decoder.Decode(&ppu.ignoreWrites)
 	decoder.Decode(&ppu.nmiOccurred)
 	decoder.Decode(&ppu.nmiOutput)
 	decoder.Decode(&ppu.nmiPrevious)
@@ -182,13 +203,36 @@ func (ppu *PPU) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+// Reset resets the PPU as the reset button does. PPUCTRL, PPUMASK, PPUSCROLL
+// and PPUADDR ignore writes until the end of the first vertical blank.
 func (ppu *PPU) Reset() {
-	ppu.Cycle = 340
-	ppu.ScanLine = 240
+	ppu.Cycle = 0
+	ppu.ScanLine = 0
 	ppu.Frame = 0
 	ppu.writeControl(0)
 	ppu.writeMask(0)
+	ppu.t = 0
+	ppu.x = 0
+	ppu.w = 0
+	ppu.f = 0
+	ppu.bufferedData = 0
+	ppu.ignoreWrites = true
+}
+
+// PowerCycle puts the PPU into its power-on state
+func (ppu *PPU) PowerCycle() {
+	ppu.paletteData = [32]byte{}
+	ppu.nameTableData = [2048]byte{}
+	ppu.oamData = [256]byte{}
+	ppu.v = 0
+	ppu.register = 0
+	ppu.nmiOccurred = false
+	ppu.nmiPrevious = false
+	ppu.nmiDelay = 0
+	ppu.flagSpriteZeroHit = 0
+	ppu.flagSpriteOverflow = 0
 	ppu.writeOAMAddress(0)
+	ppu.Reset()
 }
 
 func (ppu *PPU) readPalette(address uint16) byte {
@@ -205,6 +249,34 @@ func (ppu *PPU) writePalette(address uint16, value byte) {
 	ppu.paletteData[address] = value
 }
 
+// paletteColor looks up a palette entry, applying the PPUMASK grayscale and
+// color emphasis bits
+func (ppu *PPU) paletteColor(address uint16) color.RGBA {
+	index := ppu.readPalette(address) % 64
+	if ppu.flagGrayscale != 0 {
+		index &= 0x30
+	}
+	emphasis := ppu.flagRedTint | ppu.flagGreenTint<<1 | ppu.flagBlueTint<<2
+	return EmphasisPalettes[emphasis][index]
+}
+
+// setBusAddress reports an address placed on the PPU bus to the mapper
+func (ppu *PPU) setBusAddress(address uint16) {
+	if ppu.busWatcher != nil {
+		ppu.busWatcher.WatchPPUAddress(address)
+	}
+}
+
+// readPattern performs a rendering fetch from the pattern tables and then
+// reports it to the mapper
+func (ppu *PPU) readPattern(address uint16) byte {
+	value := ppu.Read(address)
+	if ppu.patternWatcher != nil {
+		ppu.patternWatcher.WatchPatternFetch(address)
+	}
+	return value
+}
+
 func (ppu *PPU) readRegister(address uint16) byte {
 	switch address {
 	case 0x2002:
@@ -219,6 +291,12 @@ func (ppu *PPU) readRegister(address uint16) byte {
 
 func (ppu *PPU) writeRegister(address uint16, value byte) {
 	ppu.register = value
+	if ppu.ignoreWrites {
+		switch address {
+		case 0x2000, 0x2001, 0x2005, 0x2006:
+			return
+		}
+	}
 	switch address {
 	case 0x2000:
 		ppu.writeControl(value)
@@ -333,11 +411,13 @@ func (ppu *PPU) writeAddress(value byte) {
 		ppu.t = (ppu.t & 0xFF00) | uint16(value)
 		ppu.v = ppu.t
 		ppu.w = 0
+		ppu.setBusAddress(ppu.v)
 	}
 }
 
 // $2007: PPUDATA (read)
 func (ppu *PPU) readData() byte {
+	ppu.access = ppuAccessData
 	value := ppu.Read(ppu.v)
 	// emulate buffered reads
 	if ppu.v%0x4000 < 0x3F00 {
@@ -358,6 +438,7 @@ func (ppu *PPU) readData() byte {
 
 // $2007: PPUDATA (write)
 func (ppu *PPU) writeData(value byte) {
+	ppu.access = ppuAccessData
 	ppu.Write(ppu.v, value)
 	if ppu.flagIncrement == 0 {
 		ppu.v += 1
@@ -368,17 +449,7 @@ func (ppu *PPU) writeData(value byte) {
 
 // $4014: OAMDMA
 func (ppu *PPU) writeDMA(value byte) {
-	cpu := ppu.console.CPU
-	address := uint16(value) << 8
-	for i := 0; i < 256; i++ {
-		ppu.oamData[ppu.oamAddress] = cpu.Read(address)
-		ppu.oamAddress++
-		address++
-	}
-	cpu.stall += 513
-	if cpu.Cycles%2 == 1 {
-		cpu.stall++
-	}
+	ppu.console.CPU.triggerDMA(value)
 }
 
 // NTSC Timing Helper Functions
@@ -440,9 +511,7 @@ func (ppu *PPU) copyY() {
 func (ppu *PPU) nmiChange() {
 	nmi := ppu.nmiOutput && ppu.nmiOccurred
 	if nmi && !ppu.nmiPrevious {
-		// TODO: this fixes some games but the delay shouldn't have to be so
-		// long, so the timings are off somewhere
-		ppu.nmiDelay = 15
+		ppu.nmiDelay = 1
 	}
 	ppu.nmiPrevious = nmi
 }
@@ -461,6 +530,7 @@ func (ppu *PPU) clearVerticalBlank() {
 func (ppu *PPU) fetchNameTableByte() {
 	v := ppu.v
 	address := 0x2000 | (v & 0x0FFF)
+	ppu.access = ppuAccessNameTable
 	ppu.nameTableByte = ppu.Read(address)
 }
 
@@ -468,6 +538,7 @@ func (ppu *PPU) fetchAttributeTableByte() {
 	v := ppu.v
 	address := 0x23C0 | (v & 0x0C00) | ((v >> 4) & 0x38) | ((v >> 2) & 0x07)
 	shift := ((v >> 4) & 4) | (v & 2)
+	ppu.access = ppuAccessAttribute
 	ppu.attributeTableByte = ((ppu.Read(address) >> shift) & 3) << 2
 }
 
@@ -476,7 +547,8 @@ func (ppu *PPU) fetchLowTileByte() {
 	table := ppu.flagBackgroundTable
 	tile := ppu.nameTableByte
 	address := 0x1000*uint16(table) + uint16(tile)*16 + fineY
-	ppu.lowTileByte = ppu.Read(address)
+	ppu.access = ppuAccessBackground
+	ppu.lowTileByte = ppu.readPattern(address)
 }
 
 func (ppu *PPU) fetchHighTileByte() {
@@ -484,7 +556,8 @@ func (ppu *PPU) fetchHighTileByte() {
 	table := ppu.flagBackgroundTable
 	tile := ppu.nameTableByte
 	address := 0x1000*uint16(table) + uint16(tile)*16 + fineY
-	ppu.highTileByte = ppu.Read(address + 8)
+	ppu.access = ppuAccessBackground
+	ppu.highTileByte = ppu.readPattern(address + 8)
 }
 
 func (ppu *PPU) storeTileData() {
@@ -562,7 +635,7 @@ func (ppu *PPU) renderPixel() {
 			color = background
 		}
 	}
-	c := Palette[ppu.readPalette(uint16(color))%64]
+	c := ppu.paletteColor(uint16(color))
 	ppu.back.SetRGBA(x, y, c)
 }
 
@@ -589,8 +662,9 @@ func (ppu *PPU) fetchSpritePattern(i, row int) uint32 {
 		address = 0x1000*uint16(table) + uint16(tile)*16 + uint16(row)
 	}
 	a := (attributes & 3) << 2
-	lowTileByte := ppu.Read(address)
-	highTileByte := ppu.Read(address + 8)
+	ppu.access = ppuAccessSprite
+	lowTileByte := ppu.readPattern(address)
+	highTileByte := ppu.readPattern(address + 8)
 	var data uint32
 	for i := 0; i < 8; i++ {
 		var p1, p2 byte
@@ -640,6 +714,23 @@ func (ppu *PPU) evaluateSprites() {
 		ppu.flagSpriteOverflow = 1
 	}
 	ppu.spriteCount = count
+	ppu.fetchDummySprites(8 - count)
+}
+
+// fetchDummySprites performs the pattern fetches the PPU makes for unused
+// sprite slots, which read tile $FF and are visible to mappers watching A12
+func (ppu *PPU) fetchDummySprites(n int) {
+	var address uint16
+	if ppu.flagSpriteSize == 0 {
+		address = 0x1000*uint16(ppu.flagSpriteTable) + 0xFF*16
+	} else {
+		address = 0x1000 + 0xFE*16
+	}
+	ppu.access = ppuAccessSprite
+	for i := 0; i < n; i++ {
+		ppu.readPattern(address)
+		ppu.readPattern(address + 8)
+	}
 }
 
 // tick updates Cycle, ScanLine and Frame counters
@@ -651,8 +742,10 @@ func (ppu *PPU) tick() {
 		}
 	}
 
+	timing := ppu.console.timing
 	if ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0 {
-		if ppu.f == 1 && ppu.ScanLine == 261 && ppu.Cycle == 339 {
+		if timing.skipOddFrame && ppu.f == 1 &&
+			ppu.ScanLine == timing.scanLines-1 && ppu.Cycle == 339 {
 			ppu.Cycle = 0
 			ppu.ScanLine = 0
 			ppu.Frame++
@@ -664,7 +757,7 @@ func (ppu *PPU) tick() {
 	if ppu.Cycle > 340 {
 		ppu.Cycle = 0
 		ppu.ScanLine++
-		if ppu.ScanLine > 261 {
+		if ppu.ScanLine >= timing.scanLines {
 			ppu.ScanLine = 0
 			ppu.Frame++
 			ppu.f ^= 1
@@ -676,8 +769,9 @@ func (ppu *PPU) tick() {
 func (ppu *PPU) Step() {
 	ppu.tick()
 
+	timing := ppu.console.timing
 	renderingEnabled := ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0
-	preLine := ppu.ScanLine == 261
+	preLine := ppu.ScanLine == timing.scanLines-1
 	visibleLine := ppu.ScanLine < 240
 	// postLine := ppu.ScanLine == 240
 	renderLine := preLine || visibleLine
@@ -728,15 +822,19 @@ func (ppu *PPU) Step() {
 				ppu.evaluateSprites()
 			} else {
 				ppu.spriteCount = 0
+				if preLine {
+					ppu.fetchDummySprites(8)
+				}
 			}
 		}
 	}
 
 	// vblank logic
-	if ppu.ScanLine == 241 && ppu.Cycle == 1 {
+	if ppu.ScanLine == timing.vblankLine && ppu.Cycle == 1 {
 		ppu.setVerticalBlank()
 	}
 	if preLine && ppu.Cycle == 1 {
+		ppu.ignoreWrites = false
 		ppu.clearVerticalBlank()
 		ppu.flagSpriteZeroHit = 0
 		ppu.flagSpriteOverflow = 0
@@ -0,0 +1,68 @@
+package nes
+
+// Region identifies the television system that a console was built for
+type Region byte
+
+const (
+	RegionNTSC Region = iota
+	RegionPAL
+	RegionDendy
+)
+
+func (region Region) String() string {
+	switch region {
+	case RegionPAL:
+		return "PAL"
+	case RegionDendy:
+		return "Dendy"
+	default:
+		return "NTSC"
+	}
+}
+
+// regionTiming holds the clock rates and frame layout that differ between
+// regions
+type regionTiming struct {
+	cpuFrequency     float64  // CPU clock rate in Hz
+	ppuRatio         int      // PPU cycles per 5 CPU cycles
+	scanLines        int      // scanlines per frame, including pre-render
+	vblankLine       int      // scanline on which vertical blank begins
+	skipOddFrame     bool     // odd frames drop a cycle when rendering
+	frameCounterRate float64  // CPU cycles per APU frame counter step
+	noiseTable       []uint16 // noise channel timer periods
+	dmcTable         []byte   // DMC timer periods
+}
+
+// http://wiki.nesdev.com/w/index.php/Cycle_reference_chart
+var regionTimings = [...]regionTiming{
+	RegionNTSC: {
+		cpuFrequency:     CPUFrequency,
+		ppuRatio:         15,
+		scanLines:        262,
+		vblankLine:       241,
+		skipOddFrame:     true,
+		frameCounterRate: CPUFrequency / 240.0,
+		noiseTable:       noiseTable,
+		dmcTable:         dmcTable,
+	},
+	RegionPAL: {
+		cpuFrequency:     1662607,
+		ppuRatio:         16,
+		scanLines:        312,
+		vblankLine:       241,
+		frameCounterRate: 1662607 / 200.0,
+		noiseTable:       palNoiseTable,
+		dmcTable:         palDMCTable,
+	},
+	// the Dendy pads a PAL length frame with extra post-render lines so the
+	// vertical blank is as long as on NTSC, and keeps the NTSC APU periods
+	RegionDendy: {
+		cpuFrequency:     1773448,
+		ppuRatio:         15,
+		scanLines:        312,
+		vblankLine:       291,
+		frameCounterRate: CPUFrequency / 240.0,
+		noiseTable:       noiseTable,
+		dmcTable:         dmcTable,
+	},
+}
@@ -0,0 +1,297 @@
+package nes
+
+import (
+	"sort"
+	"strings"
+)
+
+// AnySubmapper registers a mapper for every submapper that has no
+// registration of its own
+const AnySubmapper = -1
+
+// MapperInfo describes a mapper implementation and the boards it covers
+type MapperInfo struct {
+	Mapper    uint16   // iNES or NES 2.0 mapper number
+	Submapper int      // NES 2.0 submapper, or AnySubmapper
+	Name      string   // board or chip name
+	Boards    []string // UNIF board names, without their prefix
+	Games     []string // well-known games using the board
+	Battery   bool     // whether boards can have battery-backed saves
+	New       func(console *Console, cartridge *Cartridge) Mapper
+}
+
+type mapperKey struct {
+	mapper    uint16
+	submapper int
+}
+
+var (
+	mappersByNumber = map[mapperKey]*MapperInfo{}
+	mappersByBoard  = map[string]*MapperInfo{}
+)
+
+// RegisterMapper adds a mapper to the registry, replacing any earlier
+// registration for the same mapper and submapper or the same board names.
+// It is meant to be called from init functions, before any console is
+// created, and is not safe for concurrent use.
+func RegisterMapper(info MapperInfo) {
+	if info.New == nil {
+		panic("nes: RegisterMapper with no constructor for " + info.Name)
+	}
+	if info.Submapper < AnySubmapper || info.Submapper > 15 {
+		panic("nes: RegisterMapper with invalid submapper for " + info.Name)
+	}
+	p := &info
+	mappersByNumber[mapperKey{info.Mapper, info.Submapper}] = p
+	for _, board := range info.Boards {
+		mappersByBoard[board] = p
+	}
+}
+
+// LookupMapper returns the registration for a mapper and submapper,
+// falling back to the one for any submapper
+func LookupMapper(mapper uint16, submapper byte) (*MapperInfo, bool) {
+	if info, ok := mappersByNumber[mapperKey{mapper, int(submapper)}]; ok {
+		return info, true
+	}
+	info, ok := mappersByNumber[mapperKey{mapper, AnySubmapper}]
+	return info, ok
+}
+
+// LookupBoard returns the registration for a UNIF board name, ignoring the
+// NES-, HVC-, UNL-, BTL- or BMC- prefix that names usually carry
+func LookupBoard(name string) (*MapperInfo, bool) {
+	if i := strings.IndexByte(name, '-'); i >= 0 {
+		if info, ok := mappersByBoard[name[i+1:]]; ok {
+			return info, true
+		}
+	}
+	info, ok := mappersByBoard[name]
+	return info, ok
+}
+
+// Mappers returns every registration ordered by mapper and submapper
+func Mappers() []*MapperInfo {
+	result := make([]*MapperInfo, 0, len(mappersByNumber))
+	for _, info := range mappersByNumber {
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Mapper != b.Mapper {
+			return a.Mapper < b.Mapper
+		}
+		return a.Submapper < b.Submapper
+	})
+	return result
+}
+
+// the built-in mappers; UNIF board names are from
+// http://wiki.nesdev.com/w/index.php/UNIF_to_NES_2.0_Mapping
+func init() {
+	RegisterMapper(MapperInfo{
+		Mapper: 0, Submapper: AnySubmapper, Name: "NROM",
+		Boards: []string{"NROM", "NROM-128", "NROM-256", "HROM", "RROM",
+			"RTROM", "SROM", "STROM"},
+		Games: []string{"Super Mario Bros.", "Donkey Kong", "Excitebike"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper0(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 1, Submapper: AnySubmapper, Name: "MMC1",
+		Boards: []string{"SAROM", "SBROM", "SCROM", "SEROM", "SFROM",
+			"SGROM", "SHROM", "SJROM", "SKROM", "SLROM", "SL1ROM", "SNROM",
+			"SOROM", "SUROM", "SXROM"},
+		Games:   []string{"The Legend of Zelda", "Metroid", "Final Fantasy"},
+		Battery: true,
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper1(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 2, Submapper: AnySubmapper, Name: "UxROM",
+		Games: []string{"Mega Man", "Castlevania", "Contra"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper2(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 2, Submapper: 2, Name: "UxROM with bus conflicts",
+		Boards: []string{"UNROM", "UOROM"},
+		Games:  []string{"Mega Man", "Castlevania", "Contra"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper2(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 3, Submapper: AnySubmapper, Name: "CNROM",
+		Games: []string{"Arkanoid", "Paperboy", "Solomon's Key"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper3(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 3, Submapper: 2, Name: "CNROM with bus conflicts",
+		Boards: []string{"CNROM"},
+		Games:  []string{"Arkanoid", "Paperboy", "Solomon's Key"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper3(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 4, Submapper: AnySubmapper, Name: "MMC3",
+		Boards: []string{"TBROM", "TEROM", "TFROM", "TGROM", "TKROM",
+			"TLROM", "TL1ROM", "TNROM", "TR1ROM", "TSROM", "TVROM"},
+		Games:   []string{"Super Mario Bros. 3", "Mega Man 3", "Kirby's Adventure"},
+		Battery: true,
+		New:     NewMapper4,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 4, Submapper: 1, Name: "MMC6",
+		Boards:  []string{"HKROM"},
+		Games:   []string{"StarTropics", "Zoda's Revenge: StarTropics II"},
+		Battery: true,
+		New:     NewMapper4,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 5, Submapper: AnySubmapper, Name: "MMC5",
+		Boards:  []string{"EKROM", "ELROM", "ETROM", "EWROM"},
+		Games:   []string{"Castlevania III", "Just Breed", "Uncharted Waters"},
+		Battery: true,
+		New:     NewMapper5,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 7, Submapper: AnySubmapper, Name: "AxROM",
+		Boards: []string{"AOROM"},
+		Games:  []string{"Battletoads", "Marble Madness", "Wizards & Warriors"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper7(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 7, Submapper: 1, Name: "ANROM",
+		Boards: []string{"ANROM", "AN1ROM"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper7(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 7, Submapper: 2, Name: "AMROM with bus conflicts",
+		Boards: []string{"AMROM"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper7(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 9, Submapper: AnySubmapper, Name: "MMC2",
+		Boards: []string{"PNROM", "PEEOROM"},
+		Games:  []string{"Mike Tyson's Punch-Out!!"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper9(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 10, Submapper: AnySubmapper, Name: "MMC4",
+		Boards:  []string{"FJROM", "FKROM"},
+		Games:   []string{"Fire Emblem", "Famicom Wars"},
+		Battery: true,
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper9(cartridge)
+		},
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 16, Submapper: AnySubmapper, Name: "Bandai FCG",
+		Games:   []string{"Dragon Ball Z II", "SD Gundam Gaiden"},
+		Battery: true,
+		New:     NewMapper16,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 19, Submapper: AnySubmapper, Name: "Namco 163",
+		Games:   []string{"Megami Tensei II", "King of Kings"},
+		Battery: true,
+		New:     NewMapper19,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 20, Submapper: AnySubmapper, Name: "Famicom Disk System",
+		Games: []string{"The Legend of Zelda", "Metroid"},
+		New:   NewMapper20,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 21, Submapper: AnySubmapper, Name: "VRC4a/VRC4c",
+		Games:   []string{"Wai Wai World 2", "Ganbare Goemon Gaiden 2"},
+		Battery: true,
+		New:     NewMapper21,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 22, Submapper: AnySubmapper, Name: "VRC2a",
+		Games: []string{"TwinBee 3"},
+		New:   NewMapper21,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 23, Submapper: AnySubmapper, Name: "VRC2b/VRC4e",
+		Games:   []string{"Contra", "Akumajou Special"},
+		Battery: true,
+		New:     NewMapper21,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 24, Submapper: AnySubmapper, Name: "VRC6a",
+		Games: []string{"Akumajou Densetsu"},
+		New:   NewMapper24,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 25, Submapper: AnySubmapper, Name: "VRC2c/VRC4b/VRC4d",
+		Games:   []string{"Gradius II", "Teenage Mutant Ninja Turtles"},
+		Battery: true,
+		New:     NewMapper21,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 26, Submapper: AnySubmapper, Name: "VRC6b",
+		Games:   []string{"Madara", "Esper Dream 2"},
+		Battery: true,
+		New:     NewMapper24,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 40, Submapper: AnySubmapper, Name: "NTDEC 2722",
+		Games: []string{"Super Mario Bros. 2 (pirate)"},
+		New:   NewMapper40,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 69, Submapper: AnySubmapper, Name: "Sunsoft FME-7",
+		Boards:  []string{"BTR", "JLROM", "JSROM"},
+		Games:   []string{"Gimmick!", "Batman: Return of the Joker"},
+		Battery: true,
+		New:     NewMapper69,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 85, Submapper: AnySubmapper, Name: "VRC7",
+		Games:   []string{"Lagrange Point", "Tiny Toon Adventures 2"},
+		Battery: true,
+		New:     NewMapper85,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 153, Submapper: AnySubmapper, Name: "Bandai LZ93D50 with SRAM",
+		Games:   []string{"Famicom Jump II"},
+		Battery: true,
+		New:     NewMapper16,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 157, Submapper: AnySubmapper, Name: "Bandai Datach",
+		Games:   []string{"Datach Dragon Ball Z"},
+		Battery: true,
+		New:     NewMapper16,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 159, Submapper: AnySubmapper, Name: "Bandai LZ93D50 with 24C01",
+		Games:   []string{"Dragon Ball Z: Kyoushuu! Saiya Jin", "Magical Taruruuto-kun"},
+		Battery: true,
+		New:     NewMapper16,
+	})
+	RegisterMapper(MapperInfo{
+		Mapper: 225, Submapper: AnySubmapper, Name: "ET-4310/K-1010 multicart",
+		Games: []string{"52 Games", "64-in-1"},
+		New: func(console *Console, cartridge *Cartridge) Mapper {
+			return NewMapper225(cartridge)
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package nes
+
+import "testing"
+
+// TestUNIFBoards checks that UNIF boards resolve to the mapper and submapper
+// given by the UNIF to NES 2.0 mapping
+func TestUNIFBoards(t *testing.T) {
+	tests := []struct {
+		board     string
+		mapper    uint16
+		submapper byte
+	}{
+		{"NES-NROM-256", 0, 0},
+		{"NES-SNROM", 1, 0},
+		{"NES-UNROM", 2, 2},
+		{"NES-UOROM", 2, 2},
+		{"NES-CNROM", 3, 2},
+		{"NES-TLROM", 4, 0},
+		{"NES-HKROM", 4, 1},
+		{"NES-ELROM", 5, 0},
+		{"NES-ANROM", 7, 1},
+		{"NES-AN1ROM", 7, 1},
+		{"NES-AMROM", 7, 2},
+		{"NES-AOROM", 7, 0},
+		{"NES-PNROM", 9, 0},
+		{"HVC-FKROM", 10, 0},
+		{"NES-BTR", 69, 0},
+	}
+	for _, test := range tests {
+		cartridge := NewCartridge(make([]byte, 0x8000), make([]byte, 0x2000), 0, 0, 0)
+		cartridge.Board = test.board
+		if _, err := NewMapper(&Console{Cartridge: cartridge}); err != nil {
+			t.Errorf("%s: %v", test.board, err)
+			continue
+		}
+		if cartridge.Mapper != test.mapper || cartridge.Submapper != test.submapper {
+			t.Errorf("%s: mapper %d.%d, want %d.%d", test.board,
+				cartridge.Mapper, cartridge.Submapper, test.mapper, test.submapper)
+		}
+	}
+
+	cartridge := NewCartridge(make([]byte, 0x8000), nil, 0, 0, 0)
+	cartridge.Board = "UNL-NOSUCHBOARD"
+	if _, err := NewMapper(&Console{Cartridge: cartridge}); err == nil {
+		t.Error("unknown board did not fail")
+	}
+}
@@ -0,0 +1,99 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+const unifHeaderSize = 32
+
+var unifMagic = []byte("UNIF")
+
+// isUNIFFile reports whether data starts like a UNIF file
+func isUNIFFile(data []byte) bool {
+	return bytes.HasPrefix(data, unifMagic)
+}
+
+// LoadUNIFFile reads a UNIF file (.unf, .unif) and returns a Cartridge on
+// success. UNIF names the board instead of giving a mapper number, so the
+// board is kept in Cartridge.Board for NewMapper to resolve.
+// http://wiki.nesdev.com/w/index.php/UNIF
+func LoadUNIFFile(path string) (*Cartridge, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < unifHeaderSize || !isUNIFFile(data) {
+		return nil, errors.New("invalid .unf file")
+	}
+	data = data[unifHeaderSize:]
+
+	var board string
+	var prgChunks, chrChunks [16][]byte
+	mirror := byte(MirrorHorizontal)
+	var battery byte
+	region := RegionNTSC
+	for len(data) >= 8 {
+		id := string(data[:4])
+		length := int(binary.LittleEndian.Uint32(data[4:]))
+		data = data[8:]
+		if length > len(data) {
+			return nil, errors.New("truncated UNIF chunk: " + id)
+		}
+		chunk := data[:length]
+		data = data[length:]
+		switch {
+		case id == "MAPR":
+			board = nulString(chunk)
+		case strings.HasPrefix(id, "PRG") || strings.HasPrefix(id, "CHR"):
+			index := strings.IndexByte("0123456789ABCDEF", id[3])
+			if index < 0 {
+				break
+			}
+			if id[0] == 'P' {
+				prgChunks[index] = chunk
+			} else {
+				chrChunks[index] = chunk
+			}
+		case id == "MIRR" && length > 0:
+			// 0-4 match the mirroring modes; 5 means the mapper controls it
+			if chunk[0] <= MirrorFour {
+				mirror = chunk[0]
+			}
+		case id == "BATR" && length > 0:
+			battery = 1
+		case id == "TVCI" && length > 0:
+			if chunk[0] == 1 {
+				region = RegionPAL
+			}
+		}
+	}
+	if board == "" {
+		return nil, errors.New("UNIF file has no board name")
+	}
+
+	prg := bytes.Join(prgChunks[:], nil)
+	chr := bytes.Join(chrChunks[:], nil)
+	if len(prg) == 0 {
+		return nil, errors.New("UNIF file has no PRG-ROM")
+	}
+	cartridge := NewCartridge(prg, chr, 0, mirror, battery)
+	cartridge.Board = board
+	cartridge.Region = region
+	if mirror == MirrorFour {
+		cartridge.VRAM = make([]byte, 2048)
+	}
+	if battery != 0 {
+		cartridge.PRGNVRAMSize = len(cartridge.SRAM)
+	} else {
+		cartridge.PRGRAMSize = len(cartridge.SRAM)
+	}
+	if len(chr) == 0 {
+		cartridge.CHRRAMSize = 8192
+	}
+	cartridge.allocateRAM()
+	return cartridge, nil
+}
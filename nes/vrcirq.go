@@ -0,0 +1,91 @@
+package nes
+
+import "encoding/gob"
+
+// vrcPrescalerPeriod is the prescaler reload, in thirds of a CPU cycle
+const vrcPrescalerPeriod = 341
+
+// vrcIRQ is the IRQ counter shared by the Konami VRC4, VRC6 and VRC7. It
+// counts up from a latch and fires when it overflows, clocked either every
+// CPU cycle or, through a prescaler, once per NTSC scanline's worth of CPU
+// cycles whatever the region.
+type vrcIRQ struct {
+	latch       byte
+	counter     byte
+	prescaler   int
+	enable      bool
+	enableAfter bool // enable value restored by an acknowledge
+	cycleMode   bool
+	pending     bool
+}
+
+func (irq *vrcIRQ) Save(encoder *gob.Encoder) error {
+	encoder.Encode(irq.latch)
+	encoder.Encode(irq.counter)
+	encoder.Encode(irq.prescaler)
+	encoder.Encode(irq.enable)
+	encoder.Encode(irq.enableAfter)
+	encoder.Encode(irq.cycleMode)
+	encoder.Encode(irq.pending)
+	return nil
+}
+
+func (irq *vrcIRQ) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&irq.latch)
+	decoder.Decode(&irq.counter)
+	decoder.Decode(&irq.prescaler)
+	decoder.Decode(&irq.enable)
+	decoder.Decode(&irq.enableAfter)
+	decoder.Decode(&irq.cycleMode)
+	decoder.Decode(&irq.pending)
+	return nil
+}
+
+func (irq *vrcIRQ) writeLatch(value byte) {
+	irq.latch = value
+}
+
+func (irq *vrcIRQ) writeControl(value byte) {
+	irq.enableAfter = value&1 == 1
+	irq.enable = value&2 == 2
+	irq.cycleMode = value&4 == 4
+	irq.pending = false
+	if irq.enable {
+		irq.counter = irq.latch
+		irq.prescaler = vrcPrescalerPeriod
+	}
+}
+
+func (irq *vrcIRQ) acknowledge() {
+	irq.pending = false
+	irq.enable = irq.enableAfter
+}
+
+// step is called once per CPU cycle. In cycle mode it clocks the counter
+// every time; in scanline mode the prescaler counts down by 3 from 341, so
+// the counter is clocked every 113 2/3 CPU cycles.
+func (irq *vrcIRQ) step(console *Console) {
+	if irq.enable {
+		if irq.cycleMode {
+			irq.clock()
+		} else {
+			irq.prescaler -= 3
+			if irq.prescaler <= 0 {
+				irq.prescaler += vrcPrescalerPeriod
+				irq.clock()
+			}
+		}
+	}
+	if irq.pending {
+		console.CPU.triggerIRQ()
+	}
+}
+
+func (irq *vrcIRQ) clock() {
+	if irq.counter == 0xFF {
+		irq.counter = irq.latch
+		irq.pending = true
+	} else {
+		irq.counter++
+	}
+}
@@ -0,0 +1,29 @@
+package nes
+
+import "testing"
+
+// TestVRCIRQPrescaler checks that in scanline mode the counter is clocked
+// every 341/3 CPU cycles, and in cycle mode every CPU cycle
+func TestVRCIRQPrescaler(t *testing.T) {
+	console := newTestConsole(t, testProgram(0xEA))
+	tests := []struct {
+		name    string
+		control byte
+		cycles  int // CPU cycles until the IRQ from a latch of $FE
+	}{
+		{"scanline mode", 0x02, 228}, // 682 thirds of a cycle, rounded up
+		{"cycle mode", 0x06, 2},
+	}
+	for _, test := range tests {
+		var irq vrcIRQ
+		irq.writeLatch(0xFE)
+		irq.writeControl(test.control)
+		for i := 1; i <= test.cycles; i++ {
+			irq.step(console)
+			if irq.pending != (i == test.cycles) {
+				t.Errorf("%s: pending = %v after %d cycles",
+					test.name, irq.pending, i)
+			}
+		}
+	}
+}
@@ -82,6 +82,10 @@ func (d *Director) PlayGame(path string) {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if console.Cartridge.NSF != nil {
+		d.SetView(NewNSFView(d, console, path))
+		return
+	}
 	d.SetView(NewGameView(d, console, path, hash))
 }
 
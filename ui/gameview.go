@@ -2,6 +2,9 @@ package ui
 
 import (
 	"image"
+	"io/ioutil"
+	"log"
+	"os"
 
 	"github.com/fogleman/nes/nes"
 	"github.com/go-gl/gl/v2.1/gl"
@@ -18,27 +21,50 @@ type GameView struct {
 	texture  uint32
 	record   bool
 	frames   []image.Image
+	fault    error
+	badDisk  string // disk save that failed to load, kept from being overwritten
 }
 
 func NewGameView(director *Director, console *nes.Console, title, hash string) View {
 	texture := createTexture()
-	return &GameView{director, console, title, hash, texture, false, nil}
+	return &GameView{director, console, title, hash, texture, false, nil, nil, ""}
 }
 
 func (view *GameView) load(snapshot int) {
 	// load state
 	if err := view.console.LoadState(savePath(view.hash, snapshot)); err == nil {
 		return
-	} else {
-		view.console.Reset()
 	}
 	// load sram
 	cartridge := view.console.Cartridge
 	if cartridge.Battery != 0 {
-		if sram, err := readSRAM(sramPath(view.hash, snapshot)); err == nil {
+		if sram, err := readSRAM(sramPath(view.hash, snapshot), len(cartridge.SRAM)); err == nil {
 			cartridge.SRAM = sram
 		}
+		if len(cartridge.ChipRAM) > 0 {
+			path := chipRAMPath(view.hash, snapshot)
+			if ram, err := readSRAM(path, len(cartridge.ChipRAM)); err == nil {
+				cartridge.ChipRAM = ram
+			}
+		}
+	}
+	// load the changes written to a disk, or start from a clean disk
+	if len(cartridge.Disk) > 0 {
+		path := diskPath(view.hash, snapshot)
+		diff, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			diff, err = nil, nil
+		}
+		if err == nil {
+			err = cartridge.ApplyDiskDiff(diff)
+		}
+		if err != nil {
+			log.Printf("cannot load disk save %s: %v", path, err)
+			view.badDisk = path
+		}
 	}
+	// start from power-on with the save in place
+	view.console.PowerCycle()
 }
 
 func (view *GameView) save(snapshot int) {
@@ -46,6 +72,17 @@ func (view *GameView) save(snapshot int) {
 	cartridge := view.console.Cartridge
 	if cartridge.Battery != 0 {
 		writeSRAM(sramPath(view.hash, snapshot), cartridge.SRAM)
+		if len(cartridge.ChipRAM) > 0 {
+			writeSRAM(chipRAMPath(view.hash, snapshot), cartridge.ChipRAM)
+		}
+	}
+	// save disk changes
+	if len(cartridge.Disk) > 0 {
+		if path := diskPath(view.hash, snapshot); path != view.badDisk {
+			writeSRAM(path, cartridge.DiskDiff())
+		} else {
+			log.Printf("not overwriting disk save %s, which failed to load", path)
+		}
 	}
 	// save state
 	view.console.SaveState(savePath(view.hash, snapshot))
@@ -84,6 +121,7 @@ func (view *GameView) Update(t, dt float64) {
 	}
 	updateControllers(window, console)
 	console.StepSeconds(dt)
+	view.updateFault()
 	gl.BindTexture(gl.TEXTURE_2D, view.texture)
 	setTexture(console.Buffer())
 	drawBuffer(view.director.window)
@@ -93,6 +131,20 @@ func (view *GameView) Update(t, dt float64) {
 	}
 }
 
+// updateFault shows a jammed CPU in the window title until the next reset
+func (view *GameView) updateFault() {
+	fault := view.console.Fault()
+	if (fault == nil) == (view.fault == nil) {
+		return
+	}
+	view.fault = fault
+	if fault != nil {
+		view.director.SetTitle(view.title + " - " + fault.Error())
+	} else {
+		view.director.SetTitle(view.title)
+	}
+}
+
 func (view *GameView) onKey(window *glfw.Window,
 	key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
 	if action == glfw.Press {
@@ -108,7 +160,15 @@ func (view *GameView) onKey(window *glfw.Window,
 		case glfw.KeySpace:
 			screenshot(view.console.Buffer())
 		case glfw.KeyR:
-			view.console.Reset()
+			if mods&glfw.ModShift == 0 {
+				view.console.Reset()
+			} else {
+				view.console.PowerCycle()
+			}
+		case glfw.KeyE:
+			view.console.EjectDisk()
+		case glfw.KeyF:
+			view.console.FlipDisk()
 		case glfw.KeyTab:
 			if view.record {
 				view.record = false
@@ -0,0 +1,182 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/fogleman/nes/nes"
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+// NSFView plays the tracks of an NSF file. Left and right choose a track,
+// start restarts it and tracks with a known length advance on their own.
+type NSFView struct {
+	director *Director
+	console  *nes.Console
+	nsf      *nes.NSF
+	title    string
+	texture  uint32
+	screen   *image.RGBA
+	tracks   []int // play order
+	index    int
+	elapsed  float64 // seconds into the current track
+	buttons  [8]bool
+}
+
+func NewNSFView(director *Director, console *nes.Console, title string) View {
+	view := NSFView{}
+	view.director = director
+	view.console = console
+	view.nsf = console.Cartridge.NSF
+	view.title = title
+	view.texture = createTexture()
+	view.screen = image.NewRGBA(image.Rect(0, 0, 256, 240))
+	view.tracks = view.nsf.Playlist
+	if len(view.tracks) == 0 {
+		for i := 0; i < view.nsf.TotalSongs; i++ {
+			view.tracks = append(view.tracks, i)
+		}
+	}
+	for i, track := range view.tracks {
+		if track == view.nsf.StartingSong {
+			view.index = i
+		}
+	}
+	return &view
+}
+
+func (view *NSFView) Enter() {
+	gl.ClearColor(0, 0, 0, 1)
+	view.director.SetTitle(view.title)
+	view.console.SetAudioChannel(view.director.audio.channel)
+	view.console.SetAudioSampleRate(view.director.audio.sampleRate)
+	view.play(view.index)
+}
+
+func (view *NSFView) Exit() {
+	view.console.SetAudioChannel(nil)
+	view.console.SetAudioSampleRate(0)
+}
+
+func (view *NSFView) play(index int) {
+	n := len(view.tracks)
+	view.index = (index%n + n) % n
+	view.elapsed = 0
+	view.console.SetVolume(1)
+	view.console.PlayTrack(view.tracks[view.index])
+}
+
+func (view *NSFView) Update(t, dt float64) {
+	if dt > 1 {
+		dt = 0
+	}
+	window := view.director.window
+	if readKey(window, glfw.KeyEscape) ||
+		joystickReset(glfw.Joystick1) || joystickReset(glfw.Joystick2) {
+		view.director.ShowMenu()
+		return
+	}
+	view.checkButtons()
+	view.console.StepSeconds(dt)
+	view.elapsed += dt
+	view.updateFade()
+	view.draw()
+	gl.BindTexture(gl.TEXTURE_2D, view.texture)
+	setTexture(view.screen)
+	drawBuffer(window)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
+
+func (view *NSFView) checkButtons() {
+	window := view.director.window
+	k1 := readKeys(window, false)
+	j1 := readJoystick(glfw.Joystick1, false)
+	j2 := readJoystick(glfw.Joystick2, false)
+	buttons := combineButtons(combineButtons(j1, j2), k1)
+	for i := range buttons {
+		if buttons[i] && !view.buttons[i] {
+			view.onPress(i)
+		}
+	}
+	view.buttons = buttons
+}
+
+func (view *NSFView) onPress(index int) {
+	switch index {
+	case nes.ButtonLeft:
+		view.play(view.index - 1)
+	case nes.ButtonRight:
+		view.play(view.index + 1)
+	case nes.ButtonStart, nes.ButtonA:
+		view.play(view.index)
+	}
+}
+
+// updateFade fades out a track once its length has passed and then moves
+// on to the next one
+func (view *NSFView) updateFade() {
+	length, fade := view.trackLength()
+	if length < 0 || view.elapsed < length {
+		return
+	}
+	if fade > 0 && view.elapsed < length+fade {
+		view.console.SetVolume(float32(1 - (view.elapsed-length)/fade))
+		return
+	}
+	view.play(view.index + 1)
+}
+
+// trackLength returns the current track's length and fade in seconds,
+// with a length of -1 if it plays forever
+func (view *NSFView) trackLength() (length, fade float64) {
+	ms, fadeMS := view.nsf.TrackLength(view.tracks[view.index])
+	if ms < 0 {
+		return -1, 0
+	}
+	if fadeMS < 0 {
+		fadeMS = 0
+	}
+	return float64(ms) / 1000, float64(fadeMS) / 1000
+}
+
+func (view *NSFView) draw() {
+	im := view.screen
+	draw.Draw(im, im.Rect, &image.Uniform{color.Black}, image.ZP, draw.Src)
+	gray := color.RGBA{128, 128, 128, 255}
+	nsf := view.nsf
+	y := 16
+	for _, row := range WordWrap(nsf.Name, 16) {
+		drawCenteredRow(im, row, y, color.White)
+		y += 20
+	}
+	drawCenteredRow(im, nsf.Artist, y+4, gray)
+	drawCenteredRow(im, nsf.Copyright, y+24, gray)
+
+	track := view.tracks[view.index]
+	text := fmt.Sprintf("Track %d/%d", track+1, nsf.TotalSongs)
+	drawCenteredRow(im, text, 112, color.White)
+	drawCenteredRow(im, nsf.TrackName(track), 136, gray)
+	text = formatTime(view.elapsed)
+	if length, _ := view.trackLength(); length >= 0 {
+		text += "/" + formatTime(length)
+	}
+	drawCenteredRow(im, text, 168, color.White)
+	drawCenteredRow(im, "< Track >", 208, gray)
+}
+
+// drawCenteredRow draws a line of text centered across the screen, cut to
+// fit its width
+func drawCenteredRow(dst draw.Image, text string, y int, c color.Color) {
+	if len(text) > 16 {
+		text = text[:16]
+	}
+	DrawText(dst, 128-len(text)*8, y, text, c)
+}
+
+func formatTime(seconds float64) string {
+	s := int(seconds)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
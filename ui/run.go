@@ -4,6 +4,7 @@ import (
 	"log"
 	"runtime"
 
+	"github.com/fogleman/nes/nes"
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
 	"github.com/gordonklaus/portaudio"
@@ -25,6 +26,9 @@ func init() {
 }
 
 func Run(paths []string) {
+	// the Famicom Disk System BIOS is supplied by the user
+	nes.FDSBIOSPath = homeDir + "/.nes/disksys.rom"
+
 	// initialize audio
 	portaudio.Initialize()
 	defer portaudio.Terminate()
@@ -45,6 +45,24 @@ func sramPath(hash string, snapshot int) string {
 	return fmt.Sprintf("%s/.nes/sram/%s.dat", homeDir, hash)
 }
 
+// chipRAMPath is where battery-backed RAM inside the mapper chip is kept,
+// next to the save RAM file
+func chipRAMPath(hash string, snapshot int) string {
+	if snapshot >= 0 {
+		return fmt.Sprintf("%s/.nes/sram/%s-%d.chip.dat", homeDir, hash, snapshot)
+	}
+	return fmt.Sprintf("%s/.nes/sram/%s.chip.dat", homeDir, hash)
+}
+
+// diskPath is where the changes a game writes to a Famicom Disk System disk
+// are kept, separate from the original image
+func diskPath(hash string, snapshot int) string {
+	if snapshot >= 0 {
+		return fmt.Sprintf("%s/.nes/disk/%s-%d.dat", homeDir, hash, snapshot)
+	}
+	return fmt.Sprintf("%s/.nes/disk/%s.dat", homeDir, hash)
+}
+
 func savePath(hash string, snapshot int) string {
 	if snapshot >= 0 {
 		return fmt.Sprintf("%s/.nes/save/%s-%d.dat", homeDir, hash, snapshot)
@@ -228,15 +246,14 @@ func writeSRAM(filename string, sram []byte) error {
 	return binary.Write(file, binary.LittleEndian, sram)
 }
 
-func readSRAM(filename string) ([]byte, error) {
-	file, err := os.Open(filename)
+// readSRAM reads a save file into RAM of the given size. Files from a
+// board with a different amount of RAM are cut or padded with zeros.
+func readSRAM(filename string, size int) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	sram := make([]byte, 0x2000)
-	if err := binary.Read(file, binary.LittleEndian, sram); err != nil {
-		return nil, err
-	}
+	sram := make([]byte, size)
+	copy(sram, data)
 	return sram, nil
 }
@@ -11,7 +11,7 @@ import (
 	"github.com/fogleman/nes/nes"
 )
 
-func testRom(path string) (err error) {
+func testRom(path string) (info string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = r.(error)
@@ -19,10 +19,30 @@ func testRom(path string) (err error) {
 	}()
 	console, err := nes.NewConsole(path)
 	if err != nil {
-		return err
+		return "", err
 	}
+	info = romInfo(console.Cartridge)
 	console.StepSeconds(3)
-	return nil
+	return info, console.Fault()
+}
+
+// romInfo describes a cartridge: its mapper from the mapper registry,
+// and a trainer if the file has one
+func romInfo(cartridge *nes.Cartridge) string {
+	if cartridge.NSF != nil {
+		return "NSF"
+	}
+	name := fmt.Sprintf("mapper %d", cartridge.Mapper)
+	if cartridge.Submapper != 0 {
+		name += fmt.Sprintf(".%d", cartridge.Submapper)
+	}
+	if info, ok := nes.LookupMapper(cartridge.Mapper, cartridge.Submapper); ok {
+		name += ", " + info.Name
+	}
+	if len(cartridge.Trainer) > 0 {
+		name += ", trainer"
+	}
+	return name
 }
 
 func main() {
@@ -41,7 +61,10 @@ func main() {
 			continue
 		}
 		name = path.Join(dir, name)
-		err := testRom(name)
+		details, err := testRom(name)
+		if details != "" {
+			name += " (" + details + ")"
+		}
 		if err == nil {
 			fmt.Println("OK  ", name)
 		} else {